@@ -1,25 +1,49 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/evmar/ai/anthropic"
 	"github.com/evmar/ai/google"
 	"github.com/evmar/ai/image"
 	"github.com/evmar/ai/llm"
+	"github.com/evmar/ai/llm/agent"
+	"github.com/evmar/ai/llm/schema"
+	"github.com/evmar/ai/llm/session"
+	"github.com/evmar/ai/net"
 	"github.com/evmar/ai/ollama"
 	"github.com/evmar/ai/openai"
 )
 
 var (
-	flagBackend = flag.String("backend", "", "backend name to use from config")
-	flagVerbose = flag.Bool("v", false, "log http")
+	flagConfig  = flag.String("config", "", "config file to use instead of $AI_CONFIG or ~/.config/ai.toml")
+	flagBackend = flag.String("backend", "", "backend name to use from config, optionally with a model as backend/model")
+	flagModel   = flag.String("model", "", "model to use instead of the backend's configured one")
+	flagVerbose = flag.Bool("v", false, "log http to stderr")
+	flagLogFile = flag.String("log-file", "", "log http to this file (appending) instead of stderr; implies -v")
+	flagLogJSON = flag.Bool("log-json", false, "log http as one JSON object per line, with timings")
+	flagLogBody = flag.Int("log-max-body", 4096, "bytes of each body to log, or -1 for all")
+	flagRecord  = flag.String("record", "", "record http exchanges to this cassette file")
+	flagReplay  = flag.String("replay", "", "answer http requests from this cassette file instead of the network")
+	flagCache   = flag.Bool("cache", false, "answer repeated prompts from the response cache")
+	flagNoCache = flag.Bool("no-cache", false, "don't use the response cache, even if enabled in config")
+	flagUsage   = flag.Bool("usage", false, "print token usage, latency and estimated cost of each call to stderr; see also `ai usage`")
+	flagTimeout = flag.Duration("timeout", 0, "give up after this long, e.g. 30s; in chat, per reply")
 )
 
-func parseMulti(multi string) ([]string, error) {
+func parseMulti(multi string) ([]*llm.Message, error) {
 	parts := strings.SplitAfterN(multi, "\n", 2)
 	if len(parts) < 2 {
 		return nil, fmt.Errorf("expected separator as first line of multi")
@@ -40,7 +64,49 @@ func parseMulti(multi string) ([]string, error) {
 	if len(parts)%2 != 0 {
 		return nil, fmt.Errorf("expected even number of parts in multi")
 	}
-	return prompts, nil
+	return llm.Alternating(prompts...), nil
+}
+
+// attachFlags registers flags that load attachments into parts.
+func attachFlags(flags *flag.FlagSet, parts *[]llm.Part) {
+	flags.Func("image", "image to attach (repeatable)", func(val string) error {
+		img, err := image.LoadImage(val)
+		if err != nil {
+			return err
+		}
+		*parts = append(*parts, &llm.Image{MimeType: img.MimeType, Data: img.Data})
+		return nil
+	})
+	flags.Func("audio", "audio file to attach (repeatable)", func(val string) error {
+		audio, err := llm.LoadAudio(val)
+		if err != nil {
+			return err
+		}
+		*parts = append(*parts, audio)
+		return nil
+	})
+	flags.Func("file", "document to attach, e.g. a PDF (repeatable)", func(val string) error {
+		file, err := llm.LoadFile(val)
+		if err != nil {
+			return err
+		}
+		*parts = append(*parts, file)
+		return nil
+	})
+}
+
+// attach adds parts ahead of the text of the last user message.
+func attach(msgs []*llm.Message, parts []llm.Part) error {
+	if len(parts) == 0 {
+		return nil
+	}
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Role == llm.RoleUser {
+			msgs[i].Parts = append(append([]llm.Part(nil), parts...), msgs[i].Parts...)
+			return nil
+		}
+	}
+	return fmt.Errorf("attachments need a prompt to attach to")
 }
 
 func argOrStdin(arg string) (string, error) {
@@ -54,130 +120,472 @@ func argOrStdin(arg string) (string, error) {
 	return arg, nil
 }
 
+// optionFlags registers flags that override generation options.
+func optionFlags(flags *flag.FlagSet, opts *llm.Options) {
+	flags.Func("temp", "sampling temperature", func(val string) error {
+		f, err := strconv.ParseFloat(val, 64)
+		opts.Temperature = &f
+		return err
+	})
+	flags.Func("top-p", "nucleus sampling probability", func(val string) error {
+		f, err := strconv.ParseFloat(val, 64)
+		opts.TopP = &f
+		return err
+	})
+	flags.Func("max-tokens", "maximum tokens to generate", func(val string) error {
+		n, err := strconv.Atoi(val)
+		opts.MaxTokens = &n
+		return err
+	})
+	flags.Func("seed", "random seed", func(val string) error {
+		n, err := strconv.Atoi(val)
+		opts.Seed = &n
+		return err
+	})
+	flags.Func("stop", "stop sequence (repeatable)", func(val string) error {
+		opts.Stop = append(opts.Stop, val)
+		return nil
+	})
+	flags.StringVar(&opts.ReasoningEffort, "reasoning", "", "reasoning effort for reasoning models: {low,medium,high}")
+}
+
 type TTS interface {
-	CallSpeech(text, outPath string) error
+	CallSpeech(ctx context.Context, text, outPath string) error
 }
 
-func getBackend(config *llm.Config, name string) (llm.LLM, error) {
+// resolveBackend finds the named backend's config, falling back to the
+// default.  A model may be picked with -model or as "backend/model".
+func resolveBackend(config *llm.Config, name string) (string, *llm.BackendConfig, error) {
 	if name == "" {
 		name = config.DefaultBackend
 	}
 	if name == "" {
-		return nil, fmt.Errorf("specify -backend or set default_backend in config")
+		return "", nil, fmt.Errorf("specify -backend or set default_backend in config")
 	}
+	model := *flagModel
 	cfg, ok := config.Backend[name]
 	if !ok {
-		return nil, fmt.Errorf("backend %q not found", name)
+		// Split at the first slash only, as model names may have them too.
+		if base, m, found := strings.Cut(name, "/"); found {
+			if cfg, ok = config.Backend[base]; ok {
+				name = base
+				if model == "" {
+					model = m
+				}
+			}
+		}
+	}
+	if !ok {
+		return "", nil, fmt.Errorf("backend %q not found", name)
 	}
+	if model != "" {
+		c := *cfg
+		c.Model = model
+		cfg = &c
+	}
+	return name, cfg, nil
+}
 
+// sessionBackend returns the backend a resumed session continues with when
+// -backend isn't given: the backend and model it last used, as
+// "backend/model".
+func sessionBackend(sess *session.Session) string {
+	if sess.Backend != "" && sess.Model != "" {
+		return sess.Backend + "/" + sess.Model
+	}
+	return sess.Backend
+}
+
+func getBackend(config *llm.Config, name string) (llm.LLM, error) {
+	name, cfg, err := resolveBackend(config, name)
+	if err != nil {
+		return nil, err
+	}
+	return newBackend(name, cfg)
+}
+
+// transportWrapper returns how backends' transports should be wrapped for
+// -record, -replay and logging, or nil to leave them alone.  The wrappers
+// are shared, so all backends go to one cassette and log.
+var transportWrapper = sync.OnceValues(func() (func(http.RoundTripper) http.RoundTripper, error) {
+	var wrap func(http.RoundTripper) http.RoundTripper
+	switch {
+	case *flagRecord != "" && *flagReplay != "":
+		return nil, fmt.Errorf("-record and -replay are exclusive")
+	case *flagRecord != "":
+		wrap = (&net.Recorder{Path: *flagRecord}).Via
+	case *flagReplay != "":
+		c, err := net.LoadCassette(*flagReplay)
+		if err != nil {
+			return nil, err
+		}
+		replayer := net.NewReplayer(c)
+		wrap = func(http.RoundTripper) http.RoundTripper { return replayer }
+	}
+
+	if *flagVerbose || *flagLogFile != "" {
+		t := &net.LoggingTransport{JSON: *flagLogJSON, MaxBody: *flagLogBody}
+		if *flagLogFile != "" {
+			// Left open for the life of the process.
+			f, err := os.OpenFile(*flagLogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+			if err != nil {
+				return nil, err
+			}
+			t.Out = f
+		}
+		if inner := wrap; inner != nil {
+			wrap = func(rt http.RoundTripper) http.RoundTripper { return t.Via(inner(rt)) }
+		} else {
+			wrap = t.Via
+		}
+	}
+	return wrap, nil
+})
+
+func newBackend(name string, cfg *llm.BackendConfig) (llm.LLM, error) {
+	wrap, err := transportWrapper()
+	if err != nil {
+		return nil, err
+	}
+
+	var backend llm.LLM
 	switch cfg.Mode {
 	case "":
 		return nil, fmt.Errorf("backend %q needs mode= config", name)
 	case "openai":
-		c, err := openai.New()
-		if err != nil {
-			return nil, err
+		var opts []openai.Option
+		if wrap != nil {
+			opts = append(opts, openai.WithTransport(wrap))
 		}
-		c.Verbose = *flagVerbose
-		return c, nil
+		backend, err = openai.New(cfg, opts...)
 	case "ollama":
-		c, err := ollama.New(cfg)
-		if err != nil {
-			return nil, err
+		var opts []ollama.Option
+		if wrap != nil {
+			opts = append(opts, ollama.WithTransport(wrap))
 		}
-		return c, nil
+		backend, err = ollama.New(cfg, opts...)
 	case "google":
-		c, err := google.New(cfg)
+		var opts []google.Option
+		if wrap != nil {
+			opts = append(opts, google.WithTransport(wrap))
+		}
+		backend, err = google.New(cfg, opts...)
+	case "anthropic":
+		var opts []anthropic.Option
+		if wrap != nil {
+			opts = append(opts, anthropic.WithTransport(wrap))
+		}
+		backend, err = anthropic.New(cfg, opts...)
+	default:
+		return nil, fmt.Errorf("backend %q: invalid mode %q", name, cfg.Mode)
+	}
+	if err != nil {
+		// Keys and such are per backend, so say which one is misconfigured.
+		return nil, fmt.Errorf("backend %q: %w", name, err)
+	}
+	return backend, nil
+}
+
+// callStructured calls the backend and validates the output against s,
+// retrying with the validation error as feedback.
+func callStructured(ctx context.Context, backend llm.LLM, prompt *llm.Prompt, s *schema.Schema, retries int) (string, error) {
+	p := *prompt
+	p.Messages = append([]*llm.Message(nil), prompt.Messages...)
+	for attempt := 0; ; attempt++ {
+		stream, err := backend.Call(ctx, &p)
 		if err != nil {
+			return "", err
+		}
+		out, err := llm.Collect(stream)
+		if err != nil {
+			return "", err
+		}
+		verr := s.Validate([]byte(out))
+		if verr == nil {
+			return out, nil
+		}
+		if attempt >= retries {
+			return "", fmt.Errorf("output doesn't match schema: %w\n%s", verr, out)
+		}
+		p.Messages = append(p.Messages,
+			llm.TextMessage(llm.RoleAssistant, out),
+			llm.TextMessage(llm.RoleUser, fmt.Sprintf("That output doesn't match the schema (%s). Reply with corrected JSON only.", verr)),
+		)
+	}
+}
+
+// newAgent creates an agent with the tools declared in the config.
+func newAgent(config *llm.Config, backend llm.LLM) (*agent.Agent, error) {
+	a := agent.New(backend)
+	names := make([]string, 0, len(config.Tool))
+	for name := range config.Tool {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cfg := config.Tool[name]
+		if cfg.Declaration == "" {
+			return nil, fmt.Errorf("tool %q needs declaration= config", name)
+		}
+		decl, err := llm.LoadTool(os.ExpandEnv(cfg.Declaration))
+		if err != nil {
+			return nil, fmt.Errorf("tool %q: %w", name, err)
+		}
+		if err := a.RegisterCommand(decl, cfg.Command); err != nil {
 			return nil, err
 		}
-		c.Verbose = *flagVerbose
-		return c, nil
-	default:
-		return nil, fmt.Errorf("invalid backend mode %q", cfg.Mode)
 	}
+	return a, nil
 }
 
-func run(args []string) error {
-	config, err := llm.LoadConfig()
+func runAgent(ctx context.Context, config *llm.Config, backend llm.LLM, args []string) error {
+	prompt := &llm.Prompt{}
+	flags := flag.NewFlagSet("agent", flag.ExitOnError)
+	flags.StringVar(&prompt.System, "sys", "", "system prompt")
+	steps := flags.Int("steps", 10, "maximum model calls")
+	optionFlags(flags, &prompt.Options)
+	flags.Parse(args)
+	args = flags.Args()
+	if len(args) != 1 {
+		return fmt.Errorf("specify prompt")
+	}
+	text, err := argOrStdin(args[0])
 	if err != nil {
 		return err
 	}
+	prompt.Messages = append(prompt.Messages, llm.TextMessage(llm.RoleUser, text))
 
-	if len(args) < 1 {
-		return fmt.Errorf("specify mode")
+	a, err := newAgent(config, backend)
+	if err != nil {
+		return err
 	}
-	mode, args := args[0], args[1:]
-
-	backend, err := getBackend(config, *flagBackend)
+	a.MaxSteps = *steps
+	a.OnEvent = func(ev llm.Event) {
+		if call, ok := ev.(*llm.ToolCall); ok {
+			fmt.Fprintf(os.Stderr, "call: %s %s\n", call.Name, call.Arguments)
+		}
+	}
+	a.OnResult = func(result *llm.ToolResult) {
+		fmt.Fprintf(os.Stderr, "result: %s\n", strings.TrimSpace(result.Content))
+	}
+	answer, _, err := a.Run(ctx, prompt)
 	if err != nil {
 		return err
 	}
+	fmt.Println(answer)
+	return nil
+}
 
-	switch mode {
-	case "text":
-		prompt := &llm.Prompt{}
-
-		{
-			flags := flag.NewFlagSet("text", flag.ExitOnError)
-			flags.StringVar(&prompt.System, "sys", "", "system prompt")
-			multi := flags.String("multi", "", "multi-shot input")
-			flags.BoolVar(&prompt.JSON, "json", false, "output json")
-			flags.Func("image", "image to attach", func(val string) error {
-				img, err := image.LoadImage(val)
-				if err != nil {
-					return err
-				}
-				prompt.Images = append(prompt.Images, img)
-				return nil
-			})
-			flags.Parse(args)
-
-			if *multi != "" {
-				msgs, err := parseMulti(*multi)
-				if err != nil {
-					return err
-				}
-				prompt.Messages = append(prompt.Messages, msgs...)
+// printStream prints text and tool calls as they arrive and returns the
+// gathered response.
+func printStream(stream llm.Stream) (*llm.Response, error) {
+	last := ""
+	resp, err := llm.ReadResponse(llm.Observe(stream, func(ev llm.Event) {
+		switch ev := ev.(type) {
+		case *llm.TextDelta:
+			fmt.Print(ev.Text)
+			last = ev.Text
+		case *llm.ToolCall:
+			b, err := json.Marshal(ev)
+			if err != nil {
+				// Arguments may be truncated JSON if the model hit a limit.
+				b = []byte(fmt.Sprintf("%s %s", ev.Name, ev.Arguments))
 			}
-			args = flags.Args()
-			if len(args) > 1 {
-				return fmt.Errorf("too many arguments")
+			if last != "" && !strings.HasSuffix(last, "\n") {
+				fmt.Println()
 			}
-			if len(args) == 1 {
-				arg, err := argOrStdin(args[0])
-				if err != nil {
-					return err
-				}
-				prompt.Messages = append(prompt.Messages, arg)
+			last = string(b) + "\n"
+			fmt.Print(last)
+		case *llm.Finish:
+			// End the line now, before anything else (like -usage) prints.
+			if !strings.HasSuffix(last, "\n") {
+				fmt.Println()
+				last = "\n"
 			}
 		}
+	}))
+	if !strings.HasSuffix(last, "\n") {
+		fmt.Println()
+	}
+	return resp, err
+}
+
+func runText(ctx context.Context, config *llm.Config, args []string) error {
+	prompt := &llm.Prompt{}
+	var outSchema *schema.Schema
+	var retries int
+	var sessionName string
 
-		if s, ok := backend.(llm.Streamed); ok {
-			stream, err := s.CallStreamed(prompt.System, prompt.JSON, prompt.Messages)
+	{
+		flags := flag.NewFlagSet("text", flag.ExitOnError)
+		flags.StringVar(&prompt.System, "sys", "", "system prompt")
+		multi := flags.String("multi", "", "multi-shot input")
+		flags.StringVar(&sessionName, "session", "", "session to resume and save to")
+		flags.BoolVar(&prompt.JSON, "json", false, "output json")
+		flags.Func("schema", "JSON schema file the output must conform to", func(val string) error {
+			s, err := schema.Load(val)
 			if err != nil {
 				return err
 			}
-			for {
-				msg, err := stream.Next()
-				if err != nil {
-					if err != io.EOF {
-						return err
-					}
-					break
-				}
-				fmt.Print(msg)
+			outSchema = s
+			prompt.Schema = s.Raw
+			return nil
+		})
+		flags.IntVar(&retries, "retries", 0, "with -schema, times to retry nonconforming output")
+		optionFlags(flags, &prompt.Options)
+		var attachments []llm.Part
+		attachFlags(flags, &attachments)
+		flags.Func("tool", "JSON tool declaration file (repeatable)", func(val string) error {
+			tool, err := llm.LoadTool(val)
+			if err != nil {
+				return err
 			}
-		} else {
-			msg, err := backend.Call(prompt)
+			prompt.Tools = append(prompt.Tools, tool)
+			return nil
+		})
+		flags.Parse(args)
+
+		if *multi != "" {
+			msgs, err := parseMulti(*multi)
 			if err != nil {
 				return err
 			}
-			fmt.Println(msg)
+			prompt.Messages = append(prompt.Messages, msgs...)
 		}
-		return nil
+		args = flags.Args()
+		if len(args) > 1 {
+			return fmt.Errorf("too many arguments")
+		}
+		if len(args) == 1 {
+			arg, err := argOrStdin(args[0])
+			if err != nil {
+				return err
+			}
+			prompt.Messages = append(prompt.Messages, llm.TextMessage(llm.RoleUser, arg))
+		}
+		if err := attach(prompt.Messages, attachments); err != nil {
+			return err
+		}
+	}
+
+	var sess *session.Session
+	backendName := *flagBackend
+	if sessionName != "" {
+		var err error
+		sess, err = session.LoadOrNew(sessionName)
+		if err != nil {
+			return err
+		}
+		if backendName == "" {
+			backendName = sessionBackend(sess)
+		}
+	}
+	backendName, cfg, err := resolveBackend(config, backendName)
+	if err != nil {
+		return err
+	}
+	backend, err := newBackend(backendName, cfg)
+	if err != nil {
+		return err
+	}
+	backend = cached(meter(backend, config, backendName, cfg), config, backendName, cfg)
+
+	// The new messages, to be appended to the session after the call.
+	newMessages := prompt.Messages
+	if sess != nil {
+		if prompt.System != "" {
+			sess.System = prompt.System
+		}
+		prompt.System = sess.System
+		prompt.Messages = append(append([]*llm.Message(nil), sess.Messages...), newMessages...)
+	}
+
+	var reply *llm.Message
+	if outSchema != nil {
+		out, err := callStructured(ctx, backend, prompt, outSchema, retries)
+		if err != nil {
+			return err
+		}
+		fmt.Println(out)
+		reply = llm.TextMessage(llm.RoleAssistant, out)
+	} else {
+		stream, err := backend.Call(ctx, prompt)
+		if err != nil {
+			return err
+		}
+		resp, err := printStream(stream)
+		if err != nil {
+			return err
+		}
+		reply = resp.Message()
+	}
+
+	if sess != nil {
+		sess.Backend = backendName
+		sess.Model = cfg.Model
+		sess.Messages = append(sess.Messages, newMessages...)
+		sess.Messages = append(sess.Messages, reply)
+		return sess.Save()
+	}
+	return nil
+}
+
+func run(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("specify mode")
+	}
+	mode, args := args[0], args[1:]
+	// Chat bounds each call instead, or the timeout would end the session.
+	if *flagTimeout > 0 && mode != "chat" {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *flagTimeout)
+		defer cancel()
+	}
+
+	// Config commands must work without a (valid) config.
+	if mode == "config" {
+		return runConfig(args)
+	}
+	config, err := llm.LoadConfig(*flagConfig)
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case "text":
+		return runText(ctx, config, args)
+
+	case "chat":
+		return runChat(ctx, config, args)
+
+	case "sessions":
+		return runSessions(args)
+
+	case "usage":
+		return runUsage(config, args)
+
+	case "cache":
+		return runCache(config, args)
+
+	case "models":
+		return runModels(ctx, config, args)
+
+	case "agent":
+		name, cfg, err := resolveBackend(config, *flagBackend)
+		if err != nil {
+			return err
+		}
+		backend, err := newBackend(name, cfg)
+		if err != nil {
+			return err
+		}
+		return runAgent(ctx, config, cached(meter(backend, config, name, cfg), config, name, cfg), args)
 
 	case "tts":
+		backend, err := getBackend(config, *flagBackend)
+		if err != nil {
+			return err
+		}
 		tts, ok := backend.(TTS)
 		if !ok {
 			return fmt.Errorf("backend doesn't support TTS")
@@ -193,28 +601,34 @@ func run(args []string) error {
 		if err != nil {
 			return err
 		}
-		if err := tts.CallSpeech(text, "out.mp3"); err != nil {
+		if err := tts.CallSpeech(ctx, text, "out.mp3"); err != nil {
 			return err
 		}
 		return nil
-
-	case "config":
-		fmt.Println("config file:", llm.ConfigPath())
-		t, err := config.ToTOML()
-		if err != nil {
-			return err
-		}
-		fmt.Println(t)
-		return nil
 	}
 
-	return fmt.Errorf("invalid mode, must be one of {text,tts,config}")
+	return fmt.Errorf("invalid mode, must be one of {text,chat,sessions,agent,usage,cache,models,tts,config}")
 }
 
 func main() {
 	flag.Parse()
-	if err := run(flag.Args()); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+
+	// Ctrl-C cancels the in-flight request; a second one kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if err := run(ctx, flag.Args()); err != nil {
+		switch {
+		case errors.Is(err, context.Canceled):
+			fmt.Fprintln(os.Stderr, "interrupted")
+			os.Exit(130)
+		case errors.Is(err, context.DeadlineExceeded):
+			fmt.Fprintf(os.Stderr, "error: timed out after %s\n", *flagTimeout)
+		default:
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		}
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/evmar/ai/llm"
+	"github.com/evmar/ai/llm/session"
+)
+
+// fakeOllama serves chat calls, recording the model each asked for.
+func fakeOllama(t *testing.T) (*httptest.Server, *[]string) {
+	var models []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		models = append(models, req.Model)
+		w.Write([]byte(`{"message":{"role":"assistant","content":"hi"},"done":true,"prompt_eval_count":3,"eval_count":1}` + "\n"))
+	}))
+	t.Cleanup(server.Close)
+	return server, &models
+}
+
+func TestResumeSessionModel(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	server, models := fakeOllama(t)
+	config := &llm.Config{
+		DefaultBackend: "llama",
+		Backend: map[string]*llm.BackendConfig{
+			"llama": {Mode: "ollama", URL: server.URL, Model: "small"},
+		},
+	}
+	ctx := context.Background()
+
+	*flagModel = "big"
+	err := runText(ctx, config, []string{"-session", "s", "hello"})
+	*flagModel = ""
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Resumed without -model, the session keeps its model.
+	if err := runText(ctx, config, []string{"-session", "s", "again"}); err != nil {
+		t.Fatal(err)
+	}
+	// As it does when started with backend/model.
+	*flagBackend = "llama/medium"
+	err = runText(ctx, config, []string{"-session", "t", "hello"})
+	*flagBackend = ""
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runText(ctx, config, []string{"-session", "t", "again"}); err != nil {
+		t.Fatal(err)
+	}
+	// A fresh call uses the configured model.
+	if err := runText(ctx, config, []string{"hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"big", "big", "medium", "medium", "small"}
+	if len(*models) != len(want) {
+		t.Fatalf("wanted models %v, got %v", want, *models)
+	}
+	for i := range want {
+		if (*models)[i] != want[i] {
+			t.Fatalf("wanted models %v, got %v", want, *models)
+		}
+	}
+	sess, err := session.Load("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.Backend != "llama" || sess.Model != "big" || len(sess.Messages) != 4 {
+		t.Fatalf("wrong session: %+v", sess)
+	}
+}
@@ -0,0 +1,320 @@
+package anthropic
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/evmar/ai/llm"
+	"github.com/evmar/ai/net"
+)
+
+const (
+	defaultURL   = "https://api.anthropic.com/v1"
+	defaultModel = "claude-3-5-haiku-latest"
+	apiVersion   = "2023-06-01"
+	// The API requires max_tokens on every request.
+	defaultMaxTokens = 4096
+)
+
+// defaultClient connects with a timeout, unlike http.DefaultClient.
+var defaultClient = &http.Client{Transport: net.NewTransport(10 * time.Second)}
+
+type Client struct {
+	token   string
+	url     string
+	model   string
+	options llm.Options
+	retry   *net.RetryPolicy
+	http    *http.Client
+}
+
+var _ llm.LLM = (*Client)(nil)
+
+type Option func(*Client)
+
+// WithHTTPClient sends requests through hc instead of the package's own
+// client, so hc's timeouts apply.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithTransport lets wrap intercept requests, e.g. for -v logging, while
+// they still go out through the package's transport.
+func WithTransport(wrap func(inner http.RoundTripper) http.RoundTripper) Option {
+	return func(c *Client) { c.http = &http.Client{Transport: wrap(c.http.Transport)} }
+}
+
+func New(config *llm.BackendConfig, opts ...Option) (*Client, error) {
+	token, err := config.APIKey("ANTHROPIC_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		token:   token,
+		url:     strings.TrimSuffix(config.URL, "/"),
+		model:   config.Model,
+		options: config.Options,
+		http:    defaultClient,
+	}
+	c.retry = &net.RetryPolicy{
+		MaxAttempts: config.MaxAttempts,
+		Timeout:     config.RetryTimeout,
+		Retryable: func(resp *http.Response, body []byte) bool {
+			return httpError(resp, body).Retryable()
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.token == "" {
+		return nil, fmt.Errorf("set ANTHROPIC_API_KEY or api_key_env, api_key_file, api_key_cmd")
+	}
+	if c.url == "" {
+		c.url = defaultURL
+	}
+	if c.model == "" {
+		c.model = defaultModel
+	}
+	return c, nil
+}
+
+// Model returns the model calls use, which is the default if the config
+// doesn't name one.
+func (c *Client) Model() string {
+	return c.model
+}
+
+// errorBody is the JSON of an error, either an HTTP error response or an
+// "error" event mid-stream.
+type errorBody struct {
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (e *errorBody) apiError() *llm.APIError {
+	return &llm.APIError{Provider: "anthropic", Type: e.Error.Type, Message: e.Error.Message}
+}
+
+// httpError converts an HTTP error response to an APIError.
+func httpError(resp *http.Response, body []byte) *llm.APIError {
+	var e *llm.APIError
+	var eb errorBody
+	if err := json.Unmarshal(body, &eb); err == nil && eb.Error != nil {
+		e = eb.apiError()
+	} else {
+		e = &llm.APIError{Provider: "anthropic", Message: llm.Snippet(body)}
+	}
+	e.Status = resp.StatusCode
+	e.RequestID = resp.Header.Get("Request-Id")
+	return e
+}
+
+func base64Source(mimeType string, data []byte) map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "base64",
+		"media_type": mimeType,
+		"data":       base64.StdEncoding.EncodeToString(data),
+	}
+}
+
+// content converts message parts to content blocks.
+func content(parts []llm.Part) ([]map[string]interface{}, error) {
+	blocks := []map[string]interface{}{}
+	for _, part := range parts {
+		switch part := part.(type) {
+		case *llm.Text:
+			blocks = append(blocks, map[string]interface{}{"type": "text", "text": part.Text})
+		case *llm.Image:
+			blocks = append(blocks, map[string]interface{}{
+				"type":   "image",
+				"source": base64Source(part.MimeType, part.Data),
+			})
+		case *llm.File:
+			if part.MimeType != "application/pdf" {
+				return nil, fmt.Errorf("anthropic: unsupported file type %q", part.MimeType)
+			}
+			blocks = append(blocks, map[string]interface{}{
+				"type":   "document",
+				"source": base64Source(part.MimeType, part.Data),
+			})
+		case *llm.ToolCall:
+			blocks = append(blocks, map[string]interface{}{
+				"type":  "tool_use",
+				"id":    part.ID,
+				"name":  part.Name,
+				"input": part.Arguments,
+			})
+		case *llm.ToolResult:
+			blocks = append(blocks, map[string]interface{}{
+				"type":        "tool_result",
+				"tool_use_id": part.ID,
+				"content":     part.Content,
+			})
+		default:
+			return nil, fmt.Errorf("anthropic: unsupported message part %T", part)
+		}
+	}
+	return blocks, nil
+}
+
+// request builds the Messages API request for prompt.
+func (c *Client) request(prompt *llm.Prompt) (map[string]interface{}, error) {
+	messages := []map[string]interface{}{}
+	for _, msg := range prompt.Messages {
+		// Tool results are sent back as user content.
+		role := "user"
+		if msg.Role == llm.RoleAssistant {
+			role = "assistant"
+		}
+		blocks, err := content(msg.Parts)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, map[string]interface{}{
+			"role":    role,
+			"content": blocks,
+		})
+	}
+
+	params := map[string]interface{}{
+		"model":    c.model,
+		"messages": messages,
+		"stream":   true,
+	}
+
+	// There's no JSON mode, so ask for JSON in the system prompt.
+	system := prompt.System
+	if prompt.Schema != nil {
+		system = strings.TrimSpace(system + "\n\nRespond with only JSON conforming to this JSON schema:\n" + string(prompt.Schema))
+	} else if prompt.JSON {
+		system = strings.TrimSpace(system + "\n\nRespond with only JSON.")
+	}
+	if system != "" {
+		params["system"] = system
+	}
+
+	opts := c.options.Merge(prompt.Options)
+	params["max_tokens"] = defaultMaxTokens
+	if opts.MaxTokens != nil {
+		params["max_tokens"] = *opts.MaxTokens
+	}
+	if opts.Temperature != nil {
+		params["temperature"] = *opts.Temperature
+	}
+	if opts.TopP != nil {
+		params["top_p"] = *opts.TopP
+	}
+	if opts.Stop != nil {
+		params["stop_sequences"] = opts.Stop
+	}
+	// Seed isn't supported.
+
+	if len(prompt.Tools) > 0 {
+		tools := []interface{}{}
+		for _, tool := range prompt.Tools {
+			// Unlike the others, Anthropic requires a schema even for no arguments.
+			schema := tool.Parameters
+			if len(schema) == 0 {
+				schema = json.RawMessage(`{"type":"object"}`)
+			}
+			tools = append(tools, map[string]interface{}{
+				"name":         tool.Name,
+				"description":  tool.Description,
+				"input_schema": schema,
+			})
+		}
+		params["tools"] = tools
+	}
+	return params, nil
+}
+
+func (c *Client) Call(ctx context.Context, prompt *llm.Prompt) (llm.Stream, error) {
+	params, err := c.request(prompt)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{r: net.NewSSEReader(resp.Body), body: resp.Body}, nil
+}
+
+// do sends req with the API key, converting error responses to APIErrors.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("X-Api-Key", c.token)
+	req.Header.Set("Anthropic-Version", apiVersion)
+
+	resp, err := c.retry.Do(c.http, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return nil, httpError(resp, body)
+	}
+	return resp, nil
+}
+
+// ListModels lists the available models.  The API doesn't give their
+// context lengths.
+func (c *Client) ListModels(ctx context.Context) ([]*llm.Model, error) {
+	var models []*llm.Model
+	after := ""
+	for {
+		u := c.url + "/models?limit=1000"
+		if after != "" {
+			u += "&after_id=" + url.QueryEscape(after)
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var list struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+			HasMore bool   `json:"has_more"`
+			LastID  string `json:"last_id"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parsing models: %w", err)
+		}
+		for _, m := range list.Data {
+			models = append(models, &llm.Model{Name: m.ID})
+		}
+		if !list.HasMore || list.LastID == "" {
+			return models, nil
+		}
+		after = list.LastID
+	}
+}
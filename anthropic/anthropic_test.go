@@ -0,0 +1,179 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/evmar/ai/llm"
+	"github.com/evmar/ai/net"
+)
+
+func TestRequest(t *testing.T) {
+	t.Setenv("ANTHROPIC_API_KEY", "test")
+	c, err := New(&llm.BackendConfig{Model: "claude-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	temp := 0.5
+	params, err := c.request(&llm.Prompt{
+		System: "be terse",
+		Messages: []*llm.Message{
+			{Role: llm.RoleUser, Parts: []llm.Part{
+				&llm.Image{MimeType: "image/png", Data: []byte("png")},
+				&llm.Text{Text: "what's this?"},
+			}},
+			{Role: llm.RoleAssistant, Parts: []llm.Part{
+				&llm.ToolCall{ID: "toolu_1", Name: "look", Arguments: json.RawMessage(`{}`)},
+			}},
+			{Role: llm.RoleTool, Parts: []llm.Part{
+				&llm.ToolResult{ID: "toolu_1", Name: "look", Content: "a cat"},
+			}},
+		},
+		Tools:   []*llm.Tool{{Name: "look", Description: "Look around"}},
+		Options: llm.Options{Temperature: &temp, Stop: []string{"END"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"max_tokens":4096,"messages":[` +
+		`{"content":[{"source":{"data":"cG5n","media_type":"image/png","type":"base64"},"type":"image"},{"text":"what's this?","type":"text"}],"role":"user"},` +
+		`{"content":[{"id":"toolu_1","input":{},"name":"look","type":"tool_use"}],"role":"assistant"},` +
+		`{"content":[{"content":"a cat","tool_use_id":"toolu_1","type":"tool_result"}],"role":"user"}],` +
+		`"model":"claude-test","stop_sequences":["END"],"stream":true,"system":"be terse","temperature":0.5,` +
+		`"tools":[{"description":"Look around","input_schema":{"type":"object"},"name":"look"}]}`
+	if string(body) != exp {
+		t.Fatalf("wanted\n%s\ngot\n%s", exp, body)
+	}
+
+	if _, err := c.request(&llm.Prompt{Messages: []*llm.Message{
+		{Role: llm.RoleUser, Parts: []llm.Part{&llm.Audio{MimeType: "audio/wav"}}},
+	}}); err == nil {
+		t.Fatalf("wanted error for audio part")
+	}
+}
+
+func newStream(raw string) *Stream {
+	body := io.NopCloser(strings.NewReader(raw))
+	return &Stream{r: net.NewSSEReader(body), body: body}
+}
+
+func TestStream(t *testing.T) {
+	// Recorded from the API, with pings trimmed.
+	raw := `event: message_start
+data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","content":[],"model":"claude-3-5-haiku-20241022","stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":25,"output_tokens":1}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: ping
+data: {"type": "ping"}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"!"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":6}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+	s := newStream(raw)
+	resp, err := llm.ReadResponse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "Hello!" || resp.FinishReason != "end_turn" {
+		t.Fatalf("wanted Hello!/end_turn, got %q/%q", resp.Text, resp.FinishReason)
+	}
+	if resp.Usage == nil || resp.Usage.InputTokens != 25 || resp.Usage.OutputTokens != 6 {
+		t.Fatalf("wanted usage 25/6, got %+v", resp.Usage)
+	}
+
+	overloaded := "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n"
+	s = newStream(overloaded)
+	_, err = llm.ReadResponse(s)
+	var apiErr *llm.APIError
+	if !errors.As(err, &apiErr) || apiErr.Type != "overloaded_error" || !apiErr.Retryable() {
+		t.Fatalf("wanted retryable overloaded_error, got %v", err)
+	}
+}
+
+func TestHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "test" || r.Header.Get("Anthropic-Version") == "" {
+			t.Errorf("missing auth headers: %v", r.Header)
+		}
+		w.Header().Set("Request-Id", "req_1")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: Field required"}}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("ANTHROPIC_API_KEY", "test")
+	c, err := New(&llm.BackendConfig{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Call(context.Background(), &llm.Prompt{Messages: llm.Alternating("hi")})
+	var apiErr *llm.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("wanted APIError, got %v", err)
+	}
+	if apiErr.Status != 400 || apiErr.Type != "invalid_request_error" || apiErr.RequestID != "req_1" || apiErr.Retryable() {
+		t.Fatalf("wrong error: %+v", apiErr)
+	}
+}
+
+func TestReplayToolUse(t *testing.T) {
+	cassette, err := net.LoadCassette("testdata/tool_use.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ANTHROPIC_API_KEY", "test")
+	c, err := New(&llm.BackendConfig{Model: "claude-3-5-haiku-latest"},
+		WithHTTPClient(&http.Client{Transport: net.NewReplayer(cassette)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := c.Call(context.Background(), &llm.Prompt{
+		Messages: llm.Alternating("What's the weather in Paris?"),
+		Tools: []*llm.Tool{{
+			Name:        "weather",
+			Description: "Get the weather for a city",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"city":{"type":"string"}},"required":["city"]}`),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := llm.ReadResponse(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "Let me check." || resp.FinishReason != "tool_use" {
+		t.Errorf("wanted text and tool_use, got %q/%q", resp.Text, resp.FinishReason)
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].ID != "toolu_01" || string(resp.ToolCalls[0].Arguments) != `{"city":"Paris"}` {
+		t.Fatalf("wanted weather call, got %+v", resp.ToolCalls)
+	}
+	if resp.Usage == nil || resp.Usage.InputTokens != 380 || resp.Usage.OutputTokens != 58 {
+		t.Errorf("wanted usage 380/58, got %+v", resp.Usage)
+	}
+}
@@ -0,0 +1,136 @@
+package anthropic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/evmar/ai/llm"
+	"github.com/evmar/ai/net"
+)
+
+// event is one server-sent event of a streamed Messages response.
+// See https://docs.anthropic.com/en/api/messages-streaming.
+type event struct {
+	Type string `json:"type"`
+	// message_start
+	Message *struct {
+		Usage usage `json:"usage"`
+	} `json:"message"`
+	// content_block_start, content_block_delta, content_block_stop
+	Index        int `json:"index"`
+	ContentBlock *struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"content_block"`
+	// content_block_delta, message_delta
+	Delta *struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	// message_start, message_delta
+	Usage *usage `json:"usage"`
+	errorBody
+}
+
+type usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type Stream struct {
+	r       *net.SSEReader
+	body    io.Closer
+	pending []llm.Event
+	// Tool calls arrive as a block start followed by argument fragments,
+	// keyed by block index.
+	toolCalls  map[int]*llm.ToolCall
+	stopReason string
+	usage      llm.Usage
+}
+
+// handle converts one event into pending stream events.
+func (s *Stream) handle(ev *event) error {
+	switch ev.Type {
+	case "message_start":
+		if ev.Message != nil {
+			s.usage.InputTokens = ev.Message.Usage.InputTokens
+			s.usage.OutputTokens = ev.Message.Usage.OutputTokens
+		}
+	case "content_block_start":
+		if b := ev.ContentBlock; b != nil && b.Type == "tool_use" {
+			if s.toolCalls == nil {
+				s.toolCalls = map[int]*llm.ToolCall{}
+			}
+			s.toolCalls[ev.Index] = &llm.ToolCall{ID: b.ID, Name: b.Name}
+		}
+	case "content_block_delta":
+		if ev.Delta == nil {
+			break
+		}
+		switch ev.Delta.Type {
+		case "text_delta":
+			s.pending = append(s.pending, &llm.TextDelta{Text: ev.Delta.Text})
+		case "input_json_delta":
+			if call := s.toolCalls[ev.Index]; call != nil {
+				call.Arguments = append(call.Arguments, ev.Delta.PartialJSON...)
+			}
+		}
+	case "content_block_stop":
+		if call := s.toolCalls[ev.Index]; call != nil {
+			// The fragments are concatenated as sent, spaces included.
+			var args bytes.Buffer
+			if json.Compact(&args, call.Arguments) != nil || args.Len() == 0 {
+				args.Reset()
+				args.WriteString("{}")
+			}
+			call.Arguments = args.Bytes()
+			s.pending = append(s.pending, call)
+			delete(s.toolCalls, ev.Index)
+		}
+	case "message_delta":
+		if ev.Delta != nil && ev.Delta.StopReason != "" {
+			s.stopReason = ev.Delta.StopReason
+		}
+		if ev.Usage != nil {
+			// Cumulative, so the last one wins.
+			s.usage.OutputTokens = ev.Usage.OutputTokens
+		}
+	case "message_stop":
+		u := s.usage
+		s.pending = append(s.pending, &llm.Finish{Reason: s.stopReason}, &u)
+	case "error":
+		if ev.Error != nil {
+			return ev.apiError()
+		}
+	}
+	// Others, like "ping", are ignored.
+	return nil
+}
+
+func (s *Stream) Next() (llm.Event, error) {
+	for len(s.pending) == 0 {
+		data, err := s.r.Read()
+		if err != nil {
+			return nil, err
+		}
+		var ev event
+		if err := json.Unmarshal(data, &ev); err != nil {
+			return nil, fmt.Errorf("anthropic: parsing stream event %q: %w", data, err)
+		}
+		if err := s.handle(&ev); err != nil {
+			return nil, err
+		}
+	}
+	ev := s.pending[0]
+	s.pending = s.pending[1:]
+	return ev, nil
+}
+
+func (s *Stream) Close() error {
+	return s.body.Close()
+}
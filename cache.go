@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/evmar/ai/llm"
+	"github.com/evmar/ai/llm/cache"
+)
+
+func newCache(config *llm.Config) *cache.Cache {
+	c := &cache.Cache{Dir: cache.Dir()}
+	if config.Cache != nil {
+		c.TTL = config.Cache.TTL
+	}
+	return c
+}
+
+// cached wraps backend to use the response cache, if enabled by config or
+// flags.
+func cached(backend llm.LLM, config *llm.Config, name string, cfg *llm.BackendConfig) llm.LLM {
+	enabled := config.Cache != nil && config.Cache.Enabled
+	if *flagCache {
+		enabled = true
+	}
+	if *flagNoCache {
+		enabled = false
+	}
+	if !enabled {
+		return backend
+	}
+	return &cache.LLM{
+		LLM:     backend,
+		Cache:   newCache(config),
+		Backend: name,
+		Model:   effectiveModel(backend, cfg),
+		Options: cfg.Options,
+	}
+}
+
+func runCache(config *llm.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("specify one of {stats,clear}")
+	}
+	c := newCache(config)
+	switch args[0] {
+	case "stats":
+		stats, err := c.Stats()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("dir: %s\nentries: %d\nsize: %d bytes\n", c.Dir, stats.Entries, stats.Bytes)
+		if c.TTL > 0 {
+			fmt.Printf("expired: %d (ttl %s)\n", stats.Expired, c.TTL)
+		}
+		return nil
+	case "clear":
+		n, err := c.Clear()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("removed %d entries\n", n)
+		return nil
+	}
+	return fmt.Errorf("invalid cache command %q, must be one of {stats,clear}", args[0])
+}
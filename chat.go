@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/evmar/ai/llm"
+	"github.com/evmar/ai/llm/session"
+)
+
+func runChat(ctx context.Context, config *llm.Config, args []string) error {
+	prompt := &llm.Prompt{}
+	flags := flag.NewFlagSet("chat", flag.ExitOnError)
+	flags.StringVar(&prompt.System, "sys", "", "system prompt")
+	sessionName := flags.String("session", "", "session to resume and save to")
+	optionFlags(flags, &prompt.Options)
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return fmt.Errorf("chat takes no arguments; type messages on stdin")
+	}
+
+	var sess *session.Session
+	backendName := *flagBackend
+	if *sessionName != "" {
+		var err error
+		sess, err = session.LoadOrNew(*sessionName)
+		if err != nil {
+			return err
+		}
+		if backendName == "" {
+			backendName = sessionBackend(sess)
+		}
+		if prompt.System != "" {
+			sess.System = prompt.System
+		}
+		prompt.System = sess.System
+		prompt.Messages = sess.Messages
+	}
+	backendName, cfg, err := resolveBackend(config, backendName)
+	if err != nil {
+		return err
+	}
+	backend, err := newBackend(backendName, cfg)
+	if err != nil {
+		return err
+	}
+	backend = cached(meter(backend, config, backendName, cfg), config, backendName, cfg)
+
+	// Read stdin in the background so Ctrl-C at the prompt still exits.
+	lines := make(chan string)
+	readErr := make(chan error, 1)
+	go func() {
+		in := bufio.NewScanner(os.Stdin)
+		in.Buffer(nil, 1<<20)
+		for in.Scan() {
+			lines <- in.Text()
+		}
+		readErr <- in.Err()
+		close(lines)
+	}()
+
+	for {
+		fmt.Fprint(os.Stderr, "> ")
+		var line string
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(os.Stderr)
+			return ctx.Err()
+		case l, ok := <-lines:
+			if !ok {
+				fmt.Fprintln(os.Stderr)
+				return <-readErr
+			}
+			line = strings.TrimSpace(l)
+		}
+		if line == "" {
+			continue
+		}
+
+		prompt.Messages = append(prompt.Messages, llm.TextMessage(llm.RoleUser, line))
+		callCtx, cancel := callContext(ctx)
+		stream, err := backend.Call(callCtx, prompt)
+		var resp *llm.Response
+		if err == nil {
+			resp, err = printStream(stream)
+		}
+		timedOut := callCtx.Err() == context.DeadlineExceeded
+		cancel()
+		if err != nil && ctx.Err() != nil {
+			return err
+		} else if err != nil {
+			// Drop the failed turn so the user can try again.
+			prompt.Messages = prompt.Messages[:len(prompt.Messages)-1]
+			if timedOut {
+				err = fmt.Errorf("timed out after %s", *flagTimeout)
+			}
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			continue
+		}
+		prompt.Messages = append(prompt.Messages, resp.Message())
+
+		if sess != nil {
+			sess.Backend = backendName
+			sess.Model = cfg.Model
+			sess.Messages = prompt.Messages
+			if err := sess.Save(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// callContext bounds a single call by -timeout, if given.
+func callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if *flagTimeout > 0 {
+		return context.WithTimeout(ctx, *flagTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// describe summarizes a message part for display.
+func describe(part llm.Part) string {
+	switch part := part.(type) {
+	case *llm.Text:
+		return part.Text
+	case *llm.Image:
+		return fmt.Sprintf("[image %s, %d bytes]", part.MimeType, len(part.Data))
+	case *llm.Audio:
+		return fmt.Sprintf("[audio %s, %d bytes]", part.MimeType, len(part.Data))
+	case *llm.File:
+		return fmt.Sprintf("[file %s, %d bytes]", part.Name, len(part.Data))
+	case *llm.ToolCall:
+		return fmt.Sprintf("[call %s %s]", part.Name, part.Arguments)
+	case *llm.ToolResult:
+		return fmt.Sprintf("[result %s] %s", part.Name, part.Content)
+	}
+	return fmt.Sprintf("[%T]", part)
+}
+
+func runSessions(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("specify one of {list,show,rm,fork}")
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "list":
+		names, err := session.List()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			s, err := session.Load(name)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s\t%s\t%d messages\t%s\n", name, s.Updated.Format("2006-01-02 15:04"), len(s.Messages), s.Backend)
+		}
+		return nil
+
+	case "show":
+		if len(args) != 1 {
+			return fmt.Errorf("specify session name")
+		}
+		s, err := session.Load(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("backend: %s\nmodel: %s\n", s.Backend, s.Model)
+		if s.System != "" {
+			fmt.Printf("system: %s\n", s.System)
+		}
+		for _, msg := range s.Messages {
+			var parts []string
+			for _, part := range msg.Parts {
+				parts = append(parts, describe(part))
+			}
+			fmt.Printf("\n%s: %s\n", msg.Role, strings.Join(parts, "\n"))
+		}
+		return nil
+
+	case "rm":
+		if len(args) == 0 {
+			return fmt.Errorf("specify session name")
+		}
+		for _, name := range args {
+			if err := session.Remove(name); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "fork":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: sessions fork SRC DST")
+		}
+		_, err := session.Fork(args[0], args[1])
+		return err
+	}
+	return fmt.Errorf("invalid sessions command %q, must be one of {list,show,rm,fork}", cmd)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/evmar/ai/llm"
+)
+
+// configTemplate is the file written by `ai config init`.
+const configTemplate = `# Config for ai; see https://github.com/evmar/ai.
+# A .ai.toml in the current directory or its parents is merged over this.
+
+# Other config files to merge under this one, relative to it.
+# include = ["work.toml"]
+
+# The backend used when -backend isn't given.
+default_backend = "openai"
+
+[backend.openai]
+# One of openai, ollama, google, anthropic.
+mode = "openai"
+# model = "gpt-4o-mini"
+# For OpenAI-compatible servers:
+# url = "http://localhost:1234/v1"
+# "chat" for chat completions, or "responses" for the Responses API.
+# api = "chat"
+# The key defaults to $OPENAI_API_KEY; or read it from one of:
+# api_key_env = "WORK_OPENAI_KEY"
+# api_key_file = "~/.config/openai.key"
+# api_key_cmd = "pass show openai"
+# Failed requests are tried up to max_attempts times, within retry_timeout.
+# max_attempts = 3
+# retry_timeout = "2m"
+
+# [backend.openai.options]
+# temperature = 0.7
+# max_tokens = 500
+# top_p = 0.9
+# stop = ["END"]
+# seed = 1
+# reasoning_effort = "low"
+
+# [backend.llama]
+# mode = "ollama"
+# model = "llama3.2:1b"
+# url = "http://localhost:11434"
+
+# [backend.gemini]
+# mode = "google"
+# model = "gemini-1.5-flash"
+
+# [backend.claude]
+# mode = "anthropic"
+# model = "claude-3-5-haiku-latest"
+
+# Prices in dollars per million tokens, for -usage and the budget.
+# [price."gpt-4o-mini"]
+# input = 0.15
+# output = 0.60
+
+# Refuse calls once the estimated spend reaches these, in dollars.
+# [budget]
+# daily = 1.00
+# monthly = 20.00
+
+# Answer repeated prompts from the response cache.
+# [cache]
+# enabled = true
+# ttl = "24h"
+
+# Tools for ai agent.
+# [tool.decl]
+# declaration = "data/myfunc.json"
+# command = ["./decl.sh"]
+`
+
+func runConfig(args []string) error {
+	path := *flagConfig
+	if path == "" {
+		path = llm.ConfigPath()
+	}
+
+	if len(args) > 0 && args[0] == "init" {
+		flags := flag.NewFlagSet("config init", flag.ExitOnError)
+		force := flags.Bool("force", false, "overwrite an existing config")
+		flags.Parse(args[1:])
+		if flags.NArg() != 0 {
+			return fmt.Errorf("config init takes no arguments")
+		}
+		if _, err := os.Stat(path); err == nil && !*force {
+			return fmt.Errorf("%s already exists; use -force to overwrite it", path)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(configTemplate), 0600); err != nil {
+			return err
+		}
+		fmt.Println("wrote", path)
+		return nil
+	}
+	if len(args) != 0 {
+		return fmt.Errorf("specify nothing or init")
+	}
+
+	config, err := llm.LoadConfig(*flagConfig)
+	if err != nil {
+		return err
+	}
+	if len(config.Files) == 0 {
+		fmt.Printf("no config file; `ai config init` creates %s\n", path)
+	}
+	for _, f := range config.Files {
+		fmt.Println("config file:", f)
+	}
+	t, err := config.ToTOML()
+	if err != nil {
+		return err
+	}
+	fmt.Println(t)
+	return nil
+}
@@ -2,115 +2,400 @@ package google
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/evmar/ai/llm"
 	"github.com/evmar/ai/net"
 )
 
+const defaultModel = "gemini-1.5-flash"
+
+// defaultClient is the package's client, giving up on unreachable hosts
+// after ten seconds.
+var defaultClient = &http.Client{Transport: net.NewTransport(10 * time.Second)}
+
 type Client struct {
 	apikey  string
 	model   string
-	Verbose bool
+	options llm.Options
+	retry   *net.RetryPolicy
+	http    *http.Client
+}
+
+type Option func(*Client)
+
+// WithHTTPClient substitutes hc for the default client; the tests use it
+// to serve canned responses.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithTransport installs wrap(inner) as the transport, where inner is the
+// transport the client would otherwise use.
+func WithTransport(wrap func(inner http.RoundTripper) http.RoundTripper) Option {
+	return func(c *Client) { c.http = &http.Client{Transport: wrap(c.http.Transport)} }
 }
 
 var _ llm.LLM = (*Client)(nil)
 
-func New(config *llm.BackendConfig) (*Client, error) {
-	apikey := os.Getenv("GOOGLE_API_KEY")
+func New(config *llm.BackendConfig, opts ...Option) (*Client, error) {
+	apikey, err := config.APIKey("GOOGLE_API_KEY")
+	if err != nil {
+		return nil, err
+	}
 	if apikey == "" {
-		return nil, fmt.Errorf("set GOOGLE_API_KEY")
+		return nil, fmt.Errorf("set GOOGLE_API_KEY or api_key_env, api_key_file, api_key_cmd")
+	}
+	retry := &net.RetryPolicy{
+		MaxAttempts: config.MaxAttempts,
+		Timeout:     config.RetryTimeout,
+		Retryable: func(resp *http.Response, body []byte) bool {
+			return httpError(resp.StatusCode, body).Retryable()
+		},
+		// Gemini gives no Retry-After, only a RetryInfo in the body.
+		Delay: func(resp *http.Response, body []byte) (time.Duration, bool) {
+			d := httpError(resp.StatusCode, body).RetryDelay
+			return d, d > 0
+		},
+	}
+	c := &Client{apikey: apikey, model: config.Model, options: config.Options, retry: retry, http: defaultClient}
+	for _, opt := range opts {
+		opt(c)
 	}
-	return &Client{apikey: apikey, model: config.Model}, nil
+	if c.model == "" {
+		c.model = defaultModel
+	}
+	return c, nil
+}
+
+// Model returns the model calls use, which is the default if the config
+// doesn't name one.
+func (c *Client) Model() string {
+	return c.model
 }
 
-func (c *Client) call(jsonReq map[string]interface{}) (io.Reader, error) {
+// call invokes method (e.g. "generateContent") on the configured model.
+func (c *Client) call(ctx context.Context, method string, jsonReq map[string]interface{}) (io.ReadCloser, error) {
 	body, err := json.Marshal(jsonReq)
 	if err != nil {
 		return nil, err
 	}
 
-	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:streamGenerateContent?key=%s", c.model, c.apikey)
+	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:%s?key=%s", c.model, method, c.apikey)
 
-	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Add("Content-Type", "application/json")
 
-	if c.Verbose {
-		http.DefaultClient.Transport = &net.LoggingTransport{}
-	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.retry.Do(c.http, req)
 	if err != nil {
 		return nil, err
 	}
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("http status %d", resp.StatusCode)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return nil, httpError(resp.StatusCode, body)
 	}
 
 	return resp.Body, nil
 }
 
+// ListModels lists the models that can generate content.
+func (c *Client) ListModels(ctx context.Context) ([]*llm.Model, error) {
+	var models []*llm.Model
+	pageToken := ""
+	for {
+		u := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models?pageSize=1000&key=%s", c.apikey)
+		if pageToken != "" {
+			u += "&pageToken=" + url.QueryEscape(pageToken)
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.retry.Do(c.http, req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != 200 {
+			return nil, httpError(resp.StatusCode, body)
+		}
+
+		var list struct {
+			Models []struct {
+				Name                       string   `json:"name"`
+				InputTokenLimit            int      `json:"inputTokenLimit"`
+				SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+			} `json:"models"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, fmt.Errorf("parsing models: %w", err)
+		}
+		for _, m := range list.Models {
+			if !slices.Contains(m.SupportedGenerationMethods, "generateContent") {
+				continue
+			}
+			models = append(models, &llm.Model{
+				Name:          strings.TrimPrefix(m.Name, "models/"),
+				ContextLength: m.InputTokenLimit,
+			})
+		}
+		if list.NextPageToken == "" {
+			return models, nil
+		}
+		pageToken = list.NextPageToken
+	}
+}
+
+// httpError converts an HTTP error response to an APIError.
+func httpError(status int, body []byte) *llm.APIError {
+	var resp struct {
+		Error *Status `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Error == nil {
+		return &llm.APIError{Provider: "google", Status: status, Message: llm.Snippet(body)}
+	}
+	e := resp.Error.apiError()
+	e.Status = status
+	return e
+}
+
 func parseText(body []byte) (string, error) {
 	var resp GenerateContentResponse
 	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&resp); err != nil {
 		return "", fmt.Errorf("parsing response: %w", err)
 	}
-	text := resp.Candidates[0].Content.Parts[0].Text
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return "", fmt.Errorf("response has no content")
+	}
+	text := ""
+	for _, part := range resp.Candidates[0].Content.Parts {
+		text += part.Text
+	}
 	return text, nil
 }
 
-func (c *Client) Call(prompt *llm.Prompt) (string, error) {
-	panic("todo")
+func textPart(text string) map[string]interface{} {
+	return map[string]interface{}{"text": text}
 }
 
-type Stream struct {
-	s *StreamedReader
+func inlineData(mimeType string, data []byte) map[string]interface{} {
+	return map[string]interface{}{
+		"inline_data": map[string]interface{}{
+			"mime_type": mimeType,
+			"data":      data, // []byte marshals as base64
+		},
+	}
 }
 
-func (s *Stream) Next() (string, error) {
-	var resp GenerateContentResponse
-	if err := s.s.Read(&resp); err != nil {
-		return "", err
+func contentPart(part llm.Part) map[string]interface{} {
+	switch part := part.(type) {
+	case *llm.Text:
+		return textPart(part.Text)
+	case *llm.Image:
+		return inlineData(part.MimeType, part.Data)
+	case *llm.Audio:
+		return inlineData(part.MimeType, part.Data)
+	case *llm.File:
+		return inlineData(part.MimeType, part.Data)
+	case *llm.ToolCall:
+		return map[string]interface{}{
+			"functionCall": map[string]interface{}{
+				"name": part.Name,
+				"args": part.Arguments,
+			},
+		}
+	case *llm.ToolResult:
+		// The response must be an object.
+		return map[string]interface{}{
+			"functionResponse": map[string]interface{}{
+				"name":     part.Name,
+				"response": map[string]interface{}{"content": part.Content},
+			},
+		}
 	}
-	return resp.Candidates[0].Content.Parts[0].Text, nil
+	panic(fmt.Sprintf("unhandled part %T", part))
 }
 
-func (c *Client) CallStreamed(sys string, json bool, prompts []string) (llm.Stream, error) {
-	// Confusingly, the docs say that the "Content" type used for system_instruction and contents
-	// should have a parts[] array, but in fact it's just a single part.
-
+// request builds the JSON body shared by generateContent and streamGenerateContent.
+func request(prompt *llm.Prompt, opts llm.Options) (map[string]interface{}, error) {
 	contents := []map[string]interface{}{}
-	for _, prompt := range prompts {
+	for _, msg := range prompt.Messages {
+		role := "user"
+		if msg.Role == llm.RoleAssistant {
+			role = "model"
+		}
+
+		parts := []map[string]interface{}{}
+		for _, part := range msg.Parts {
+			parts = append(parts, contentPart(part))
+		}
+
 		contents = append(contents, map[string]interface{}{
-			"parts": map[string]interface{}{
-				"text": prompt,
-			},
+			"role":  role,
+			"parts": parts,
 		})
 	}
 
 	jsonReq := map[string]interface{}{
 		"contents": contents,
 	}
-	if sys != "" {
+	if prompt.System != "" {
 		jsonReq["system_instruction"] = map[string]interface{}{
-			"parts": map[string]interface{}{
-				"text": sys,
-			},
+			"parts": []map[string]interface{}{textPart(prompt.System)},
+		}
+	}
+	if len(prompt.Tools) > 0 {
+		decls, err := functionDeclarations(prompt.Tools)
+		if err != nil {
+			return nil, err
+		}
+		jsonReq["tools"] = []map[string]interface{}{
+			{"functionDeclarations": decls},
 		}
 	}
+	genConfig := map[string]interface{}{}
+	if prompt.JSON || prompt.Schema != nil {
+		genConfig["response_mime_type"] = "application/json"
+	}
+	if prompt.Schema != nil {
+		schema, err := responseSchema(prompt.Schema)
+		if err != nil {
+			return nil, err
+		}
+		genConfig["response_schema"] = schema
+	}
+	if opts.Temperature != nil {
+		genConfig["temperature"] = *opts.Temperature
+	}
+	if opts.MaxTokens != nil {
+		genConfig["maxOutputTokens"] = *opts.MaxTokens
+	}
+	if opts.TopP != nil {
+		genConfig["topP"] = *opts.TopP
+	}
+	if opts.Stop != nil {
+		genConfig["stopSequences"] = opts.Stop
+	}
+	if opts.Seed != nil {
+		genConfig["seed"] = *opts.Seed
+	}
+	if len(genConfig) > 0 {
+		jsonReq["generationConfig"] = genConfig
+	}
+	return jsonReq, nil
+}
 
-	r, err := c.call(jsonReq)
+// Generate makes a non-streaming generateContent call and returns the full text.
+func (c *Client) Generate(ctx context.Context, prompt *llm.Prompt) (string, error) {
+	jsonReq, err := request(prompt, c.options.Merge(prompt.Options))
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	r, err := c.call(ctx, "generateContent", jsonReq)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
 	}
+	return parseText(body)
+}
+
+type Stream struct {
+	s       *StreamedReader
+	body    io.Closer
+	pending []llm.Event
+	// Every chunk carries the usage so far; only the last is reported.
+	usage *llm.Usage
+}
+
+// events converts one streamed response chunk into stream events.
+func events(resp *GenerateContentResponse) []llm.Event {
+	var evs []llm.Event
+	if len(resp.Candidates) == 0 {
+		return evs
+	}
+	cand := resp.Candidates[0]
+	if cand.Content != nil {
+		for _, part := range cand.Content.Parts {
+			if part.Text != "" {
+				evs = append(evs, &llm.TextDelta{Text: part.Text})
+			}
+			if fc := part.FunctionCall; fc != nil {
+				// Gemini pretty-prints its responses, arguments included.
+				var args bytes.Buffer
+				if json.Compact(&args, fc.Args) != nil || args.Len() == 0 {
+					args.Reset()
+					args.WriteString("{}")
+				}
+				evs = append(evs, &llm.ToolCall{Name: fc.Name, Arguments: args.Bytes()})
+			}
+		}
+	}
+	if cand.FinishReason != "" {
+		evs = append(evs, &llm.Finish{Reason: cand.FinishReason})
+	}
+	return evs
+}
+
+func (s *Stream) Next() (llm.Event, error) {
+	for len(s.pending) == 0 {
+		var resp GenerateContentResponse
+		if err := s.s.Read(&resp); err == io.EOF && s.usage != nil {
+			s.pending = append(s.pending, s.usage)
+			s.usage = nil
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if resp.Error != nil {
+			return nil, resp.Error.apiError()
+		}
+		s.pending = events(&resp)
+		if resp.UsageMetadata != nil {
+			s.usage = resp.UsageMetadata.event()
+		}
+	}
+	ev := s.pending[0]
+	s.pending = s.pending[1:]
+	return ev, nil
+}
 
-	return &Stream{s: NewStreamedReader(r)}, nil
+func (s *Stream) Close() error {
+	return s.body.Close()
 }
 
-var _ llm.Streamed = &Client{}
+func (c *Client) Call(ctx context.Context, prompt *llm.Prompt) (llm.Stream, error) {
+	jsonReq, err := request(prompt, c.options.Merge(prompt.Options))
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.call(ctx, "streamGenerateContent", jsonReq)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Stream{s: NewStreamedReader(r), body: r}, nil
+}
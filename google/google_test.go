@@ -1,6 +1,16 @@
 package google
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evmar/ai/llm"
+)
 
 func TestResponse(t *testing.T) {
 	resp := `{
@@ -34,3 +44,318 @@ func TestResponse(t *testing.T) {
 		t.Fatalf("wanted %q, got %q", exp, out)
 	}
 }
+
+func TestRequest(t *testing.T) {
+	prompt := &llm.Prompt{
+		System:   "be terse",
+		JSON:     true,
+		Messages: llm.Alternating("what is this?", "a cat", "what color?"),
+	}
+	first := prompt.Messages[0]
+	first.Parts = append([]llm.Part{&llm.Image{MimeType: "image/png", Data: []byte("png")}}, first.Parts...)
+	temp := 0.5
+	jsonReq, err := request(prompt, llm.Options{Temperature: &temp, Stop: []string{"END"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(jsonReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"contents":[` +
+		`{"parts":[{"inline_data":{"data":"cG5n","mime_type":"image/png"}},{"text":"what is this?"}],"role":"user"},` +
+		`{"parts":[{"text":"a cat"}],"role":"model"},` +
+		`{"parts":[{"text":"what color?"}],"role":"user"}],` +
+		`"generationConfig":{"response_mime_type":"application/json","stopSequences":["END"],"temperature":0.5},` +
+		`"system_instruction":{"parts":[{"text":"be terse"}]}}`
+	if string(body) != exp {
+		t.Fatalf("wanted\n%s\ngot\n%s", exp, body)
+	}
+}
+
+func TestFunctionCall(t *testing.T) {
+	raw := `{
+  "candidates": [
+    {
+      "content": {
+        "parts": [
+          {
+            "functionCall": {
+              "name": "decl",
+              "args": {"return_type": "int", "name": "main"}
+            }
+          }
+        ],
+        "role": "model"
+      },
+      "finishReason": "STOP"
+    }
+  ]
+}`
+	var resp GenerateContentResponse
+	if err := json.Unmarshal([]byte(raw), &resp); err != nil {
+		t.Fatal(err)
+	}
+	evs := events(&resp)
+	if len(evs) != 2 {
+		t.Fatalf("wanted 2 events, got %d", len(evs))
+	}
+	call, ok := evs[0].(*llm.ToolCall)
+	if !ok || call.Name != "decl" || string(call.Arguments) != `{"return_type":"int","name":"main"}` {
+		t.Fatalf("wanted decl tool call, got %#v", evs[0])
+	}
+}
+
+func TestHTTPError(t *testing.T) {
+	tests := []struct {
+		status    int
+		body      string
+		typ       string
+		delay     time.Duration
+		retryable bool
+	}{
+		{
+			status: 429,
+			body: `{
+  "error": {
+    "code": 429,
+    "message": "Resource has been exhausted (e.g. check quota).",
+    "status": "RESOURCE_EXHAUSTED"
+  }
+}`,
+			typ: "RESOURCE_EXHAUSTED",
+			// Without a RetryInfo, it may be a quota that waiting won't fix.
+			retryable: false,
+		},
+		{
+			status: 400,
+			body: `{
+  "error": {
+    "code": 400,
+    "message": "API key not valid. Please pass a valid API key.",
+    "status": "INVALID_ARGUMENT",
+    "details": [
+      {
+        "@type": "type.googleapis.com/google.rpc.ErrorInfo",
+        "reason": "API_KEY_INVALID",
+        "domain": "googleapis.com"
+      }
+    ]
+  }
+}`,
+			typ:       "INVALID_ARGUMENT",
+			retryable: false,
+		},
+		{
+			// A per-minute rate limit, as sent by the API.
+			status: 429,
+			body: `{
+  "error": {
+    "code": 429,
+    "message": "You exceeded your current quota, please check your plan and billing details. For more information on this error, head to: https://ai.google.dev/gemini-api/docs/rate-limits.",
+    "status": "RESOURCE_EXHAUSTED",
+    "details": [
+      {
+        "@type": "type.googleapis.com/google.rpc.QuotaFailure",
+        "violations": [
+          {
+            "quotaMetric": "generativelanguage.googleapis.com/generate_content_free_tier_requests",
+            "quotaId": "GenerateRequestsPerMinutePerProjectPerModel-FreeTier",
+            "quotaDimensions": {
+              "location": "global",
+              "model": "gemini-2.0-flash"
+            },
+            "quotaValue": "15"
+          }
+        ]
+      },
+      {
+        "@type": "type.googleapis.com/google.rpc.Help",
+        "links": [
+          {
+            "description": "Learn more about Gemini API quotas",
+            "url": "https://ai.google.dev/gemini-api/docs/rate-limits"
+          }
+        ]
+      },
+      {
+        "@type": "type.googleapis.com/google.rpc.RetryInfo",
+        "retryDelay": "43s"
+      }
+    ]
+  }
+}`,
+			typ:       "RESOURCE_EXHAUSTED",
+			delay:     43 * time.Second,
+			retryable: true,
+		},
+		{
+			status:    502,
+			body:      "<html>Bad Gateway</html>",
+			retryable: true,
+		},
+	}
+	for _, test := range tests {
+		err := httpError(test.status, []byte(test.body))
+		if err.Status != test.status || err.Type != test.typ || err.RetryDelay != test.delay {
+			t.Errorf("wanted status %d type %q delay %s, got %+v", test.status, test.typ, test.delay, err)
+		}
+		if err.Retryable() != test.retryable {
+			t.Errorf("%d %s: wanted retryable=%v", test.status, test.typ, test.retryable)
+		}
+	}
+}
+
+func TestDefaultModel(t *testing.T) {
+	t.Setenv("GOOGLE_API_KEY", "test")
+	c, err := New(&llm.BackendConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Model() != defaultModel {
+		t.Fatalf("wanted model %s, got %q", defaultModel, c.Model())
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	t.Setenv("GOOGLE_API_KEY", "test")
+	c, err := New(&llm.BackendConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"error":{"code":429,"status":"RESOURCE_EXHAUSTED","details":[{"@type":"type.googleapis.com/google.rpc.RetryInfo","retryDelay":"43s"}]}}`
+	resp := &http.Response{StatusCode: 429}
+	if d, ok := c.retry.Delay(resp, []byte(body)); !ok || d != 43*time.Second {
+		t.Fatalf("wanted the RetryInfo delay of 43s, got %s %v", d, ok)
+	}
+	if _, ok := c.retry.Delay(resp, []byte(`{"error":{"code":429,"status":"RESOURCE_EXHAUSTED"}}`)); ok {
+		t.Fatalf("wanted no delay without RetryInfo")
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestListModels(t *testing.T) {
+	pages := map[string]string{
+		"": `{"models":[{"name":"models/gemini-1.5-flash","inputTokenLimit":1000000,"supportedGenerationMethods":["generateContent","countTokens"]},
+			{"name":"models/embedding-001","inputTokenLimit":2048,"supportedGenerationMethods":["embedContent"]}],"nextPageToken":"p2"}`,
+		"p2": `{"models":[{"name":"models/gemini-2.0-flash","inputTokenLimit":1048576,"supportedGenerationMethods":["generateContent"]}]}`,
+	}
+	hc := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Method != "GET" || r.URL.Path != "/v1beta/models" || r.URL.Query().Get("key") != "test" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		body := pages[r.URL.Query().Get("pageToken")]
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body)), Request: r}, nil
+	})}
+	t.Setenv("GOOGLE_API_KEY", "test")
+	c, err := New(&llm.BackendConfig{}, WithHTTPClient(hc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	models, err := c.ListModels(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 2 || *models[0] != (llm.Model{Name: "gemini-1.5-flash", ContextLength: 1000000}) || models[1].Name != "gemini-2.0-flash" {
+		t.Fatalf("wrong models: %+v", models)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	hc := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Method != "POST" || r.URL.Path != "/v1beta/models/gemini-test:generateContent" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		body := `{"candidates":[{"content":{"parts":[{"text":"a "},{"text":"cat"}],"role":"model"},"finishReason":"STOP"}]}`
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body)), Request: r}, nil
+	})}
+	t.Setenv("GOOGLE_API_KEY", "test")
+	c, err := New(&llm.BackendConfig{Model: "gemini-test"}, WithHTTPClient(hc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := c.Generate(context.Background(), &llm.Prompt{Messages: llm.Alternating("what is this?")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "a cat" {
+		t.Fatalf("wanted %q, got %q", "a cat", text)
+	}
+}
+
+func TestResponseSchema(t *testing.T) {
+	schema, err := responseSchema(json.RawMessage(`{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"properties": {
+			"name": {"type": ["string", "null"], "title": "Name"},
+			"kind": {"const": "func"},
+			"args": {"type": "array", "items": {"type": "string"}}
+		},
+		"required": ["name"],
+		"additionalProperties": false
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"properties":{"args":{"items":{"type":"string"},"type":"array"},"kind":{"enum":["func"]},"name":{"nullable":true,"type":"string"}},"required":["name"],"type":"object"}`
+	if string(body) != exp {
+		t.Fatalf("wanted\n%s\ngot\n%s", exp, body)
+	}
+
+	for _, bad := range []string{
+		`{"type": "object", "properties": {"a": {"pattern": "x"}}}`,
+		`{"type": ["string", "integer"]}`,
+		`{"additionalProperties": {"type": "string"}}`,
+		`{"items": [{"type": "string"}]}`,
+	} {
+		if _, err := responseSchema(json.RawMessage(bad)); err == nil {
+			t.Errorf("%s: wanted error", bad)
+		}
+	}
+}
+
+func TestToolSchema(t *testing.T) {
+	prompt := &llm.Prompt{
+		Messages: llm.Alternating("what's the weather?"),
+		Tools: []*llm.Tool{
+			{Name: "weather", Description: "Get the weather", Parameters: json.RawMessage(`{
+				"$schema": "https://json-schema.org/draft/2020-12/schema",
+				"type": "object",
+				"properties": {
+					"city": {"type": "string"},
+					"unit": {"type": ["string", "null"]}
+				},
+				"required": ["city"],
+				"additionalProperties": false
+			}`)},
+			{Name: "now", Description: "Get the time"},
+		},
+	}
+	jsonReq, err := request(prompt, llm.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(jsonReq["tools"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `[{"functionDeclarations":[` +
+		`{"description":"Get the weather","name":"weather","parameters":{"properties":{"city":{"type":"string"},"unit":{"nullable":true,"type":"string"}},"required":["city"],"type":"object"}},` +
+		`{"description":"Get the time","name":"now"}]}]`
+	if string(body) != exp {
+		t.Fatalf("wanted\n%s\ngot\n%s", exp, body)
+	}
+
+	prompt.Tools = []*llm.Tool{{Name: "bad", Parameters: json.RawMessage(`{"type": "object", "patternProperties": {}}`)}}
+	if _, err := request(prompt, llm.Options{}); err == nil || !strings.Contains(err.Error(), "tool bad parameters #") {
+		t.Fatalf("wanted error naming the tool, got %v", err)
+	}
+}
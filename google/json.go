@@ -3,10 +3,51 @@ package google
 // The underlying API appears to be protobufs, and the official API uses them.
 // Using JSON here just avoids pulling in protobuf code.
 
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/evmar/ai/llm"
+)
+
 type GenerateContentResponse struct {
 	Candidates []*Candidate `json:"candidates"`
+	// Error is set when an error is reported within a stream.
+	Error *Status `json:"error"`
 	// PromptFeedback *PromptFeedback `json:"promptFeedback"`
-	// UsageMetadata *UsageMetadata `json:"usageMetadata"`
+	UsageMetadata *UsageMetadata `json:"usageMetadata"`
+}
+
+type UsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
+
+func (u *UsageMetadata) event() *llm.Usage {
+	return &llm.Usage{InputTokens: u.PromptTokenCount, OutputTokens: u.CandidatesTokenCount}
+}
+
+// Status is the body of an error response.
+type Status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"` // e.g. "RESOURCE_EXHAUSTED"
+	Details []struct {
+		Type string `json:"@type"`
+		// In a google.rpc.RetryInfo, e.g. "30s".
+		RetryDelay string `json:"retryDelay"`
+	} `json:"details"`
+}
+
+func (s *Status) apiError() *llm.APIError {
+	e := &llm.APIError{Provider: "google", Type: s.Status, Message: s.Message}
+	for _, d := range s.Details {
+		if d.Type == "type.googleapis.com/google.rpc.RetryInfo" {
+			e.RetryDelay, _ = time.ParseDuration(d.RetryDelay)
+		}
+	}
+	return e
 }
 
 type Candidate struct {
@@ -21,5 +62,11 @@ type Content struct {
 }
 
 type Part struct {
-	Text string `json:"text"`
+	Text         string        `json:"text"`
+	FunctionCall *FunctionCall `json:"functionCall"`
+}
+
+type FunctionCall struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args"`
 }
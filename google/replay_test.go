@@ -0,0 +1,58 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/evmar/ai/llm"
+	"github.com/evmar/ai/net"
+)
+
+// replay returns a client that answers from the named testdata cassette.
+func replay(t *testing.T, name string) *Client {
+	cassette, err := net.LoadCassette("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOOGLE_API_KEY", "test")
+	c, err := New(&llm.BackendConfig{Model: "gemini-1.5-flash", MaxAttempts: 1},
+		WithHTTPClient(&http.Client{Transport: net.NewReplayer(cassette)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestReplayStream(t *testing.T) {
+	c := replay(t, "stream.json")
+	stream, err := c.Call(context.Background(), &llm.Prompt{
+		System:   "Be brief.",
+		Messages: llm.Alternating("What's the weather in Paris?"),
+		Tools: []*llm.Tool{{
+			Name:        "weather",
+			Description: "Get the weather for a city",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"city":{"type":"string"}},"required":["city"]}`),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := llm.ReadResponse(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "Let me check." {
+		t.Errorf("wanted text, got %q", resp.Text)
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].Name != "weather" || string(resp.ToolCalls[0].Arguments) != `{"city":"Paris"}` {
+		t.Fatalf("wanted weather call, got %+v", resp.ToolCalls)
+	}
+	if resp.FinishReason != "STOP" {
+		t.Errorf("wanted STOP, got %q", resp.FinishReason)
+	}
+	if resp.Usage == nil || resp.Usage.InputTokens != 24 || resp.Usage.OutputTokens != 9 {
+		t.Errorf("wanted usage 24/9, got %+v", resp.Usage)
+	}
+}
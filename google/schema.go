@@ -0,0 +1,126 @@
+package google
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/evmar/ai/llm"
+)
+
+// responseSchema converts a JSON schema to the OpenAPI subset Gemini takes
+// as response_schema, or reports the keyword it can't express.
+// See https://ai.google.dev/api/caching#Schema.
+func responseSchema(raw json.RawMessage) (map[string]interface{}, error) {
+	var s interface{}
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	return convertSchema("response schema #", s)
+}
+
+// functionDeclarations converts tools to Gemini's declarations, whose
+// parameters are the same OpenAPI subset as response_schema.
+func functionDeclarations(tools []*llm.Tool) ([]map[string]interface{}, error) {
+	var decls []map[string]interface{}
+	for _, tool := range tools {
+		decl := map[string]interface{}{
+			"name":        tool.Name,
+			"description": tool.Description,
+		}
+		if len(tool.Parameters) > 0 {
+			var s interface{}
+			if err := json.Unmarshal(tool.Parameters, &s); err != nil {
+				return nil, fmt.Errorf("tool %s: %w", tool.Name, err)
+			}
+			params, err := convertSchema(fmt.Sprintf("tool %s parameters #", tool.Name), s)
+			if err != nil {
+				return nil, err
+			}
+			decl["parameters"] = params
+		}
+		decls = append(decls, decl)
+	}
+	return decls, nil
+}
+
+func convertSchema(path string, v interface{}) (map[string]interface{}, error) {
+	s, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: Gemini needs an object, got %v", path, v)
+	}
+	keys := make([]string, 0, len(s))
+	for key := range s {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := map[string]interface{}{}
+	for _, key := range keys {
+		val := s[key]
+		sub := path + "/" + key
+		switch key {
+		case "description", "enum", "required", "format", "nullable",
+			"minItems", "maxItems", "minLength", "maxLength", "minimum", "maximum":
+			out[key] = val
+		case "$schema", "$id", "$comment", "title", "default", "examples":
+			// Annotations.
+		case "type":
+			switch t := val.(type) {
+			case string:
+				out["type"] = t
+			case []interface{}:
+				// ["string", "null"] is the one union OpenAPI can say.
+				var types []string
+				for _, t := range t {
+					if t != "null" {
+						types = append(types, fmt.Sprint(t))
+					}
+				}
+				if len(types) != 1 || len(types) == len(t) {
+					return nil, fmt.Errorf("%s: Gemini doesn't support type %v", sub, t)
+				}
+				out["type"] = types[0]
+				out["nullable"] = true
+			}
+		case "const":
+			out["enum"] = []interface{}{val}
+		case "additionalProperties":
+			// Gemini only produces the listed properties anyway.
+			if val != false {
+				return nil, fmt.Errorf("%s: Gemini only supports false", sub)
+			}
+		case "properties":
+			props, _ := val.(map[string]interface{})
+			outProps := map[string]interface{}{}
+			for name, prop := range props {
+				p, err := convertSchema(sub+"/"+name, prop)
+				if err != nil {
+					return nil, err
+				}
+				outProps[name] = p
+			}
+			out["properties"] = outProps
+		case "items":
+			items, err := convertSchema(sub, val)
+			if err != nil {
+				return nil, err
+			}
+			out["items"] = items
+		case "anyOf":
+			list, _ := val.([]interface{})
+			var outList []interface{}
+			for i, item := range list {
+				s, err := convertSchema(fmt.Sprintf("%s/%d", sub, i), item)
+				if err != nil {
+					return nil, err
+				}
+				outList = append(outList, s)
+			}
+			out["anyOf"] = outList
+		default:
+			return nil, fmt.Errorf("%s: Gemini doesn't support %q", path, key)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,139 @@
+// Package agent runs a model in a loop, executing the tools it calls and
+// feeding the results back until it produces a final answer.
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/evmar/ai/llm"
+)
+
+// Func implements a tool.  args is the JSON arguments object from the model,
+// and the returned string is sent back as the tool's result.
+type Func func(ctx context.Context, args json.RawMessage) (string, error)
+
+type tool struct {
+	decl *llm.Tool
+	fn   Func
+}
+
+type Agent struct {
+	llm   llm.LLM
+	tools []*tool
+
+	// MaxSteps limits the number of model calls in one Run.
+	MaxSteps int
+	// OnEvent, if set, is called for each event as it streams from the model.
+	OnEvent func(ev llm.Event)
+	// OnResult, if set, is called with each tool result before it's sent back.
+	OnResult func(result *llm.ToolResult)
+}
+
+func New(backend llm.LLM) *Agent {
+	return &Agent{llm: backend, MaxSteps: 10}
+}
+
+// Register adds a tool implemented by a Go function.
+func (a *Agent) Register(decl *llm.Tool, fn Func) {
+	a.tools = append(a.tools, &tool{decl: decl, fn: fn})
+}
+
+// RegisterCommand adds a tool implemented by an external command.  The
+// command receives the arguments object on stdin and its stdout is the result.
+func (a *Agent) RegisterCommand(decl *llm.Tool, argv []string) error {
+	if len(argv) == 0 {
+		return fmt.Errorf("tool %q: empty command", decl.Name)
+	}
+	a.Register(decl, func(ctx context.Context, args json.RawMessage) (string, error) {
+		cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+		cmd.Stdin = bytes.NewReader(args)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return "", fmt.Errorf("%w: %s", err, msg)
+			}
+			return "", err
+		}
+		return string(out), nil
+	})
+	return nil
+}
+
+func (a *Agent) lookup(name string) *tool {
+	for _, t := range a.tools {
+		if t.decl.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// call runs one tool call.  Failures are reported to the model as the
+// result rather than ending the run, so it has a chance to recover.
+func (a *Agent) call(ctx context.Context, call *llm.ToolCall) *llm.ToolResult {
+	result := &llm.ToolResult{ID: call.ID, Name: call.Name}
+	t := a.lookup(call.Name)
+	if t == nil {
+		result.Content = fmt.Sprintf("error: no tool named %q", call.Name)
+		return result
+	}
+	out, err := t.fn(ctx, call.Arguments)
+	if err != nil {
+		result.Content = fmt.Sprintf("error: %s", err)
+		return result
+	}
+	result.Content = out
+	return result
+}
+
+func (a *Agent) step(ctx context.Context, prompt *llm.Prompt) (*llm.Response, error) {
+	stream, err := a.llm.Call(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+	if a.OnEvent == nil {
+		return llm.ReadResponse(stream)
+	}
+	return llm.ReadResponse(llm.Observe(stream, a.OnEvent))
+}
+
+// Run calls the model with prompt plus the registered tools, executing tool
+// calls until the model answers without one.  It returns the final text and
+// the full conversation, including tool calls and results.
+func (a *Agent) Run(ctx context.Context, prompt *llm.Prompt) (string, []*llm.Message, error) {
+	p := *prompt
+	p.Messages = append([]*llm.Message(nil), prompt.Messages...)
+	p.Tools = append([]*llm.Tool(nil), prompt.Tools...)
+	for _, t := range a.tools {
+		p.Tools = append(p.Tools, t.decl)
+	}
+
+	for i := 0; i < a.MaxSteps; i++ {
+		resp, err := a.step(ctx, &p)
+		if err != nil {
+			return "", p.Messages, err
+		}
+		p.Messages = append(p.Messages, resp.Message())
+		if len(resp.ToolCalls) == 0 {
+			return resp.Text, p.Messages, nil
+		}
+
+		results := &llm.Message{Role: llm.RoleTool}
+		for _, call := range resp.ToolCalls {
+			result := a.call(ctx, call)
+			if a.OnResult != nil {
+				a.OnResult(result)
+			}
+			results.Parts = append(results.Parts, result)
+		}
+		p.Messages = append(p.Messages, results)
+	}
+	return "", p.Messages, fmt.Errorf("agent: no answer after %d steps", a.MaxSteps)
+}
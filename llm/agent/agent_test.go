@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/evmar/ai/llm"
+	"github.com/evmar/ai/llm/llmtest"
+)
+
+var addTool = &llm.Tool{
+	Name:       "add",
+	Parameters: json.RawMessage(`{"type":"object","properties":{"a":{"type":"number"},"b":{"type":"number"}}}`),
+}
+
+func add(ctx context.Context, args json.RawMessage) (string, error) {
+	var ab struct{ A, B int }
+	if err := json.Unmarshal(args, &ab); err != nil {
+		return "", err
+	}
+	return fmt.Sprint(ab.A + ab.B), nil
+}
+
+func toolCall(id, name, args string) []llm.Event {
+	return []llm.Event{
+		&llm.ToolCall{ID: id, Name: name, Arguments: json.RawMessage(args)},
+		&llm.Finish{Reason: "tool_calls"},
+	}
+}
+
+func TestRun(t *testing.T) {
+	fake := &llmtest.Fake{Responses: [][]llm.Event{
+		toolCall("1", "add", `{"a":2,"b":3}`),
+		llmtest.Text("The answer is 5."),
+	}}
+	a := New(fake)
+	a.Register(addTool, add)
+
+	prompt := &llm.Prompt{Messages: llm.Alternating("what is 2+3?")}
+	text, msgs, err := a.Run(context.Background(), prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "The answer is 5." {
+		t.Fatalf("wanted final answer, got %q", text)
+	}
+	if len(msgs) != 4 {
+		t.Fatalf("wanted 4 messages, got %d", len(msgs))
+	}
+
+	if len(fake.Prompts) != 2 {
+		t.Fatalf("wanted 2 calls, got %d", len(fake.Prompts))
+	}
+	if tools := fake.Prompts[0].Tools; len(tools) != 1 || tools[0].Name != "add" {
+		t.Fatalf("wanted add tool declared, got %v", tools)
+	}
+	msg := fake.Prompts[1].Messages[2]
+	if msg.Role != llm.RoleTool || len(msg.Parts) != 1 {
+		t.Fatalf("wanted one tool result, got %+v", msg)
+	}
+	if result := msg.Parts[0].(*llm.ToolResult); result.ID != "1" || result.Content != "5" {
+		t.Fatalf("wanted tool result 5 for call 1, got %+v", result)
+	}
+	if len(prompt.Messages) != 1 {
+		t.Fatalf("Run modified caller's prompt")
+	}
+}
+
+func TestToolError(t *testing.T) {
+	fake := &llmtest.Fake{Responses: [][]llm.Event{
+		toolCall("1", "missing", `{}`),
+		toolCall("2", "add", `not json`),
+		llmtest.Text("sorry"),
+	}}
+	a := New(fake)
+	a.Register(addTool, add)
+
+	if _, _, err := a.Run(context.Background(), &llm.Prompt{Messages: llm.Alternating("hi")}); err != nil {
+		t.Fatal(err)
+	}
+	for i, exp := range []string{`error: no tool named "missing"`, "error: invalid character"} {
+		msgs := fake.Prompts[i+1].Messages
+		got := msgs[len(msgs)-1].Parts[0].(*llm.ToolResult).Content
+		if !strings.HasPrefix(got, exp) {
+			t.Errorf("wanted result prefix %q, got %q", exp, got)
+		}
+	}
+}
+
+func TestMaxSteps(t *testing.T) {
+	fake := &llmtest.Fake{Responses: [][]llm.Event{
+		toolCall("1", "add", `{"a":1,"b":1}`),
+		toolCall("2", "add", `{"a":1,"b":1}`),
+	}}
+	a := New(fake)
+	a.Register(addTool, add)
+	a.MaxSteps = 2
+
+	_, _, err := a.Run(context.Background(), &llm.Prompt{Messages: llm.Alternating("loop")})
+	if err == nil || !strings.Contains(err.Error(), "no answer after 2 steps") {
+		t.Fatalf("wanted step limit error, got %v", err)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	fake := &llmtest.Fake{Responses: [][]llm.Event{
+		toolCall("1", "echo", `{"x":1}`),
+		llmtest.Text("done"),
+	}}
+	a := New(fake)
+	if err := a.RegisterCommand(&llm.Tool{Name: "echo"}, []string{"cat"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := a.Run(context.Background(), &llm.Prompt{Messages: llm.Alternating("hi")}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := fake.Prompts[1].Messages
+	if got := msgs[len(msgs)-1].Parts[0].(*llm.ToolResult).Content; got != `{"x":1}` {
+		t.Fatalf("wanted command to echo args, got %q", got)
+	}
+}
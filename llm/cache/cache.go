@@ -0,0 +1,230 @@
+// Package cache stores completed responses on disk so identical prompts can
+// be answered without calling the backend again.
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/evmar/ai/llm"
+)
+
+// Dir returns the default cache directory.
+func Dir() string {
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		dir = os.ExpandEnv("$HOME/.cache")
+	}
+	return filepath.Join(dir, "ai", "responses")
+}
+
+type Cache struct {
+	Dir string
+	// TTL is how long entries stay valid; 0 means forever.
+	TTL time.Duration
+}
+
+// Key hashes everything that determines a call's output.
+func Key(backend, model string, prompt *llm.Prompt, opts llm.Options) (string, error) {
+	buf, err := json.Marshal(struct {
+		Backend  string          `json:"backend"`
+		Model    string          `json:"model"`
+		System   string          `json:"system"`
+		JSON     bool            `json:"json"`
+		Schema   json.RawMessage `json:"schema"`
+		Messages []*llm.Message  `json:"messages"`
+		Tools    []*llm.Tool     `json:"tools"`
+		Options  llm.Options     `json:"options"`
+	}{backend, model, prompt.System, prompt.JSON, prompt.Schema, prompt.Messages, prompt.Tools, opts})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// jsonEvent is the serialized form of an llm.Event.
+type jsonEvent struct {
+	Type     string        `json:"type"`
+	Text     string        `json:"text,omitempty"`
+	ToolCall *llm.ToolCall `json:"tool_call,omitempty"`
+	Reason   string        `json:"reason,omitempty"`
+	Usage    *llm.Usage    `json:"usage,omitempty"`
+}
+
+type entry struct {
+	Created time.Time   `json:"created"`
+	Events  []jsonEvent `json:"events"`
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.Dir, key+".json")
+}
+
+func (c *Cache) expired(created time.Time) bool {
+	return c.TTL > 0 && time.Since(created) > c.TTL
+}
+
+// Get returns the events stored under key, if present and not expired.
+func (c *Cache) Get(key string) ([]llm.Event, bool) {
+	buf, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var e entry
+	if err := json.Unmarshal(buf, &e); err != nil || c.expired(e.Created) {
+		return nil, false
+	}
+	var events []llm.Event
+	for _, je := range e.Events {
+		switch je.Type {
+		case "text":
+			events = append(events, &llm.TextDelta{Text: je.Text})
+		case "tool_call":
+			events = append(events, je.ToolCall)
+		case "finish":
+			events = append(events, &llm.Finish{Reason: je.Reason})
+		case "usage":
+			events = append(events, je.Usage)
+		default:
+			return nil, false
+		}
+	}
+	return events, true
+}
+
+// Put stores events under key.
+func (c *Cache) Put(key string, events []llm.Event) error {
+	e := entry{Created: time.Now()}
+	for _, ev := range events {
+		switch ev := ev.(type) {
+		case *llm.TextDelta:
+			e.Events = append(e.Events, jsonEvent{Type: "text", Text: ev.Text})
+		case *llm.ToolCall:
+			e.Events = append(e.Events, jsonEvent{Type: "tool_call", ToolCall: ev})
+		case *llm.Finish:
+			e.Events = append(e.Events, jsonEvent{Type: "finish", Reason: ev.Reason})
+		case *llm.Usage:
+			e.Events = append(e.Events, jsonEvent{Type: "usage", Usage: ev})
+		default:
+			return fmt.Errorf("cache: unknown event %T", ev)
+		}
+	}
+	buf, err := json.Marshal(&e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return err
+	}
+	tmp := c.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path(key))
+}
+
+// Stats describes the cache's contents.
+type Stats struct {
+	Entries int
+	Expired int
+	Bytes   int64
+}
+
+func (c *Cache) Stats() (*Stats, error) {
+	stats := &Stats{}
+	err := c.each(func(path string, info os.FileInfo) error {
+		stats.Entries++
+		stats.Bytes += info.Size()
+		if c.TTL > 0 && time.Since(info.ModTime()) > c.TTL {
+			stats.Expired++
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// Clear removes all entries and returns how many there were.
+func (c *Cache) Clear() (int, error) {
+	n := 0
+	err := c.each(func(path string, info os.FileInfo) error {
+		n++
+		return os.Remove(path)
+	})
+	return n, err
+}
+
+func (c *Cache) each(fn func(path string, info os.FileInfo) error) error {
+	entries, err := os.ReadDir(c.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		if err := fn(filepath.Join(c.Dir, e.Name()), info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LLM answers calls from the cache when it can, and caches the backend's
+// responses that complete successfully.
+type LLM struct {
+	llm.LLM
+	Cache          *Cache
+	Backend, Model string
+	// Options are the backend's configured options, which prompts override.
+	Options llm.Options
+}
+
+func (l *LLM) Call(ctx context.Context, prompt *llm.Prompt) (llm.Stream, error) {
+	key, err := Key(l.Backend, l.Model, prompt, l.Options.Merge(prompt.Options))
+	if err != nil {
+		return nil, err
+	}
+	if events, ok := l.Cache.Get(key); ok {
+		return llm.StaticStream(events...), nil
+	}
+	stream, err := l.LLM.Call(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+	return &recorder{Stream: stream, cache: l.Cache, key: key}, nil
+}
+
+// recorder passes a stream through, storing it once it ends cleanly.
+type recorder struct {
+	llm.Stream
+	cache  *Cache
+	key    string
+	events []llm.Event
+}
+
+func (r *recorder) Next() (llm.Event, error) {
+	ev, err := r.Stream.Next()
+	if err == nil {
+		r.events = append(r.events, ev)
+	} else if err == io.EOF {
+		// Failing to cache shouldn't fail an otherwise good call.
+		r.cache.Put(r.key, r.events)
+	}
+	return ev, err
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evmar/ai/llm"
+	"github.com/evmar/ai/llm/llmtest"
+)
+
+func call(t *testing.T, backend llm.LLM, prompt *llm.Prompt) *llm.Response {
+	stream, err := backend.Call(context.Background(), prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := llm.ReadResponse(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestCachedCall(t *testing.T) {
+	fake := &llmtest.Fake{Responses: [][]llm.Event{
+		{
+			&llm.TextDelta{Text: "Let me"},
+			&llm.TextDelta{Text: " check."},
+			&llm.ToolCall{ID: "1", Name: "weather", Arguments: json.RawMessage(`{"city":"Paris"}`)},
+			&llm.Finish{Reason: "tool_calls"},
+			&llm.Usage{InputTokens: 10, OutputTokens: 5},
+		},
+		llmtest.Text("warmer"),
+	}}
+	c := &Cache{Dir: t.TempDir()}
+	backend := &LLM{LLM: fake, Cache: c, Backend: "test", Model: "m"}
+
+	prompt := &llm.Prompt{Messages: llm.Alternating("weather?")}
+	first := call(t, backend, prompt)
+	second := call(t, backend, prompt)
+	if len(fake.Prompts) != 1 {
+		t.Fatalf("wanted 1 backend call, got %d", len(fake.Prompts))
+	}
+	if second.Text != "Let me check." || len(second.ToolCalls) != 1 || second.ToolCalls[0].Name != "weather" ||
+		second.FinishReason != "tool_calls" || second.Usage == nil || second.Usage.OutputTokens != 5 {
+		t.Fatalf("cached response differs: %+v, first was %+v", second, first)
+	}
+
+	// A different option is a different prompt.
+	temp := 0.5
+	prompt.Options.Temperature = &temp
+	if resp := call(t, backend, prompt); resp.Text != "warmer" {
+		t.Fatalf("wanted uncached response, got %q", resp.Text)
+	}
+
+	stats, err := c.Stats()
+	if err != nil || stats.Entries != 2 {
+		t.Fatalf("wanted 2 entries, got %+v, %v", stats, err)
+	}
+	if n, err := c.Clear(); err != nil || n != 2 {
+		t.Fatalf("wanted 2 entries cleared, got %d, %v", n, err)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	c := &Cache{Dir: t.TempDir(), TTL: time.Hour}
+	if err := c.Put("k", llmtest.Text("hi")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("k"); !ok {
+		t.Fatalf("wanted fresh entry")
+	}
+
+	// Backdate the entry past its TTL.
+	path := filepath.Join(c.Dir, "k.json")
+	buf, _ := os.ReadFile(path)
+	var e entry
+	json.Unmarshal(buf, &e)
+	e.Created = e.Created.Add(-2 * time.Hour)
+	buf, _ = json.Marshal(&e)
+	os.WriteFile(path, buf, 0600)
+	if _, ok := c.Get("k"); ok {
+		t.Fatalf("wanted expired entry to miss")
+	}
+}
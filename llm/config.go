@@ -1,8 +1,15 @@
 package llm
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
@@ -10,24 +17,416 @@ import (
 type Config struct {
 	DefaultBackend string                    `toml:"default_backend"`
 	Backend        map[string]*BackendConfig `toml:"backend"`
+	Tool           map[string]*ToolConfig    `toml:"tool"`
+	// Price maps model names to their prices, for estimating costs.
+	Price map[string]*Price `toml:"price"`
+	// Budget, if set, refuses calls once the estimated spending reaches it.
+	Budget *Budget      `toml:"budget"`
+	Cache  *CacheConfig `toml:"cache"`
+
+	// Include lists more config files, relative to this one, which are
+	// merged under it.
+	Include []string `toml:"include,omitempty"`
+
+	// Files lists the config files loaded, in the order they were merged.
+	Files []string `toml:"-"`
+}
+
+// CacheConfig configures the response cache; see the -cache flag.
+type CacheConfig struct {
+	Enabled bool `toml:"enabled"`
+	// TTL is how long cached responses are used, e.g. "24h"; 0 means forever.
+	TTL time.Duration `toml:"ttl,omitempty"`
+}
+
+// Budget limits dollar spending, as estimated from the configured prices.
+// Zero means no limit.
+type Budget struct {
+	Daily   float64 `toml:"daily,omitempty"`
+	Monthly float64 `toml:"monthly,omitempty"`
+}
+
+// Price is a model's price in dollars per million tokens.
+type Price struct {
+	Input  float64 `toml:"input"`
+	Output float64 `toml:"output"`
+}
+
+// Cost estimates the dollar cost of the given usage.
+func (p *Price) Cost(u *Usage) float64 {
+	return (float64(u.InputTokens)*p.Input + float64(u.OutputTokens)*p.Output) / 1e6
 }
 
 type BackendConfig struct {
-	Mode  string `toml:"mode"`
-	URL   string `toml:"url"`
-	Model string `toml:"model"`
+	Mode    string  `toml:"mode"`
+	URL     string  `toml:"url"`
+	Model   string  `toml:"model"`
+	Options Options `toml:"options"`
+	// API selects the endpoint for openai mode: "chat" (the default) for
+	// chat completions, or "responses" for the Responses API.
+	API string `toml:"api,omitempty"`
+
+	// The API key is read from at most one of these, in place of the
+	// backend's usual environment variable like $OPENAI_API_KEY.
+	// APIKeyEnv names another environment variable, APIKeyFile a file
+	// holding the key, and APIKeyCmd a shell command that prints it,
+	// e.g. "pass show openai".
+	APIKeyEnv  string `toml:"api_key_env,omitempty"`
+	APIKeyFile string `toml:"api_key_file,omitempty"`
+	APIKeyCmd  string `toml:"api_key_cmd,omitempty"`
+
+	// MaxAttempts bounds how many times a failed request is tried;
+	// 0 means the default of 3, 1 disables retries.
+	MaxAttempts int `toml:"max_attempts,omitempty"`
+	// RetryTimeout bounds the total time spent retrying, e.g. "2m".
+	RetryTimeout time.Duration `toml:"retry_timeout,omitempty"`
+}
+
+// APIKey resolves the backend's API key from its configured source, or
+// else from the environment variable defaultEnv.  Only an unset defaultEnv
+// gives an empty key without an error.
+func (c *BackendConfig) APIKey(defaultEnv string) (string, error) {
+	set := 0
+	for _, src := range []string{c.APIKeyEnv, c.APIKeyFile, c.APIKeyCmd} {
+		if src != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return "", fmt.Errorf("set only one of api_key_env, api_key_file, api_key_cmd")
+	}
+
+	var key string
+	switch {
+	case c.APIKeyEnv != "":
+		key = os.Getenv(c.APIKeyEnv)
+		if key == "" {
+			return "", fmt.Errorf("api_key_env: $%s is not set", c.APIKeyEnv)
+		}
+	case c.APIKeyFile != "":
+		path := expandPath(c.APIKeyFile)
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("api_key_file: %w", err)
+		}
+		key = strings.TrimSpace(string(buf))
+		if key == "" {
+			return "", fmt.Errorf("api_key_file: %s is empty", path)
+		}
+	case c.APIKeyCmd != "":
+		cmd := exec.Command("sh", "-c", c.APIKeyCmd)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("api_key_cmd %q: %w", c.APIKeyCmd, err)
+		}
+		// Commands like `pass show` print the secret on the first line.
+		key, _, _ = strings.Cut(string(out), "\n")
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return "", fmt.Errorf("api_key_cmd %q printed nothing", c.APIKeyCmd)
+		}
+	default:
+		key = os.Getenv(defaultEnv)
+	}
+	return key, nil
+}
+
+// ToolConfig declares an external command the agent may run as a tool.
+type ToolConfig struct {
+	// Declaration is the path to a JSON tool declaration like data/myfunc.json.
+	Declaration string `toml:"declaration"`
+	// Command receives the call's arguments as JSON on stdin.
+	Command []string `toml:"command"`
 }
 
+// expandPath expands environment variables and a leading ~/ in path.
+func expandPath(path string) string {
+	path = os.ExpandEnv(path)
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		path = filepath.Join(os.Getenv("HOME"), rest)
+	}
+	return path
+}
+
+// ConfigPath returns the path of the user's config file: $AI_CONFIG if set,
+// else ai.toml in $XDG_CONFIG_HOME (by default ~/.config).
 func ConfigPath() string {
-	return os.ExpandEnv("$HOME/.config/ai.toml")
+	if path := os.Getenv("AI_CONFIG"); path != "" {
+		return path
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = os.ExpandEnv("$HOME/.config")
+	}
+	return filepath.Join(dir, "ai.toml")
 }
 
-func LoadConfig() (*Config, error) {
-	var config Config
-	if _, err := toml.DecodeFile(ConfigPath(), &config); err != nil {
+// ProjectConfigPath returns the nearest .ai.toml in dir or its parents,
+// or "" if there is none.
+func ProjectConfigPath(dir string) string {
+	for {
+		path := filepath.Join(dir, ".ai.toml")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// LoadConfig loads the config file at path, or at ConfigPath() if path is
+// empty, with any project .ai.toml merged over it.  A missing config file
+// is only an error if it was named explicitly, by path or $AI_CONFIG.
+func LoadConfig(path string) (*Config, error) {
+	explicit := path != "" || os.Getenv("AI_CONFIG") != ""
+	if path == "" {
+		path = ConfigPath()
+	}
+	project := ""
+	if wd, err := os.Getwd(); err == nil {
+		project = ProjectConfigPath(wd)
+	}
+	config, err := loadConfig(path, explicit, project)
+	if err != nil {
 		return nil, fmt.Errorf("loading config: %w", err)
 	}
-	return &config, nil
+	return config, nil
+}
+
+var modes = []string{"openai", "ollama", "google", "anthropic"}
+
+// configFile is one parsed config file.
+type configFile struct {
+	path string
+	data []byte
+	raw  map[string]interface{}
+}
+
+func loadConfig(path string, explicit bool, project string) (*Config, error) {
+	var files []*configFile
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) && !explicit {
+		// Run with an empty config.
+	} else {
+		files, err = readIncludes(path, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if project != "" && project != path {
+		f, err := readConfigFile(project)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkProjectConfig(f); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	// Files are merged key by key, so a project can override just a
+	// backend's model, say.
+	raw := map[string]interface{}{}
+	for _, f := range files {
+		mergeTables(raw, f.raw)
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(raw); err != nil {
+		return nil, fmt.Errorf("merging config: %w", err)
+	}
+	var config Config
+	if _, err := toml.Decode(buf.String(), &config); err != nil {
+		return nil, fmt.Errorf("merging config: %w", err)
+	}
+	for _, f := range files {
+		config.Files = append(config.Files, f.path)
+	}
+
+	// Report a bad mode where it was set, which is the last file setting it.
+	var errs []error
+	for name, b := range config.Backend {
+		if b.Mode == "" || slices.Contains(modes, b.Mode) {
+			continue
+		}
+		key := toml.Key{"backend", name, "mode"}
+		for i := len(files) - 1; i >= 0; i-- {
+			if line := keyLine(files[i].data, key); line > 0 {
+				errs = append(errs, fmt.Errorf("%s:%d: backend %q: invalid mode %q, must be one of {%s}",
+					files[i].path, line, name, b.Mode, strings.Join(modes, ",")))
+				break
+			}
+		}
+	}
+	slices.SortFunc(errs, func(a, b error) int { return strings.Compare(a.Error(), b.Error()) })
+	return &config, errors.Join(errs...)
+}
+
+// readIncludes reads the config file at path and the files it includes, in
+// the order they merge: included files first, so path overrides them.
+// parents are the files including path, to catch cycles.
+func readIncludes(path string, parents []string) ([]*configFile, error) {
+	if slices.Contains(parents, path) {
+		return nil, fmt.Errorf("%s: include cycle", path)
+	}
+	f, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []*configFile
+	includes, _ := f.raw["include"].([]interface{})
+	for i, inc := range includes {
+		// Decoding into Config already checked these are strings.
+		incPath := expandPath(inc.(string))
+		if !filepath.IsAbs(incPath) {
+			incPath = filepath.Join(filepath.Dir(path), incPath)
+		}
+		fs, err := readIncludes(incPath, append(parents, path))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: include[%d]: %w", path, keyLine(f.data, toml.Key{"include"}), i, err)
+		}
+		files = append(files, fs...)
+	}
+	// Each file's includes are resolved now; the merged config has none.
+	delete(f.raw, "include")
+	return append(files, f), nil
+}
+
+// checkProjectConfig limits a project .ai.toml to picking among the user's
+// backends and tuning them.  A checked-out repository mustn't be able to
+// send keys to another host, read files, or run commands.
+func checkProjectConfig(f *configFile) error {
+	var errs []error
+	reject := func(key toml.Key) {
+		errs = append(errs, fmt.Errorf("%s:%d: %s isn't allowed in a project config, only default_backend and backend models and options",
+			f.path, keyLine(f.data, key), key))
+	}
+	for k, v := range f.raw {
+		if k == "default_backend" {
+			continue
+		}
+		backends, ok := v.(map[string]interface{})
+		if k != "backend" || !ok {
+			reject(toml.Key{k})
+			continue
+		}
+		for name, b := range backends {
+			fields, ok := b.(map[string]interface{})
+			if !ok {
+				reject(toml.Key{k, name})
+				continue
+			}
+			for field := range fields {
+				if field != "model" && field != "options" {
+					reject(toml.Key{k, name, field})
+				}
+			}
+		}
+	}
+	slices.SortFunc(errs, func(a, b error) int { return strings.Compare(a.Error(), b.Error()) })
+	return errors.Join(errs...)
+}
+
+// readConfigFile parses and validates a single config file.
+func readConfigFile(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &configFile{path: path, data: data}
+	if _, err := toml.Decode(string(data), &f.raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var config Config
+	md, err := toml.Decode(string(data), &config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// Undecoded lists every key of an unknown table; report only the table.
+	var errs []error
+	var last toml.Key
+	for _, key := range md.Undecoded() {
+		if last != nil && len(key) > len(last) && slices.Equal(key[:len(last)], last) {
+			continue
+		}
+		last = key
+		errs = append(errs, fmt.Errorf("%s:%d: unknown key %s", path, keyLine(data, key), key))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return f, nil
+}
+
+// mergeTables merges src into dst, recursing into tables.
+func mergeTables(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if vt, ok := v.(map[string]interface{}); ok {
+			if dt, ok := dst[k].(map[string]interface{}); ok {
+				mergeTables(dt, vt)
+				continue
+			}
+			dt := map[string]interface{}{}
+			mergeTables(dt, vt)
+			v = dt
+		}
+		dst[k] = v
+	}
+}
+
+// keyLine finds the line where key, or a table within it, is first defined.
+// The toml package doesn't expose key positions, so this follows the table
+// headers and key assignments line by line; it returns 0 if key isn't found.
+func keyLine(data []byte, key toml.Key) int {
+	var table []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		var path []string
+		switch {
+		case line == "" || line[0] == '#':
+			continue
+		case line[0] == '[':
+			header, _, _ := strings.Cut(line, "]")
+			table = splitKey(strings.TrimLeft(header, "["))
+			path = table
+		default:
+			name, _, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			path = append(slices.Clip(table), splitKey(name)...)
+		}
+		if len(path) >= len(key) && slices.Equal(path[:len(key)], key) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// splitKey splits a dotted TOML key like `price."gpt-4o"` into its parts.
+func splitKey(s string) []string {
+	var parts []string
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(strings.TrimPrefix(s, ".")) {
+		var part string
+		if q := s[0]; q == '"' || q == '\'' {
+			end := strings.IndexByte(s[1:], q) + 1
+			if end == 0 {
+				end = len(s)
+			}
+			part, s = s[1:end], strings.TrimSpace(s[min(end+1, len(s)):])
+		} else {
+			end := strings.IndexByte(s, '.')
+			if end < 0 {
+				end = len(s)
+			}
+			part, s = strings.TrimSpace(s[:end]), s[end:]
+		}
+		parts = append(parts, part)
+	}
+	return parts
 }
 
 func (c *Config) ToTOML() (string, error) {
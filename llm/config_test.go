@@ -0,0 +1,185 @@
+package llm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAPIKey(t *testing.T) {
+	t.Setenv("TEST_DEFAULT_KEY", "default")
+	t.Setenv("TEST_OTHER_KEY", "other")
+	file := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(file, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		config BackendConfig
+		key    string
+	}{
+		{BackendConfig{}, "default"},
+		{BackendConfig{APIKeyEnv: "TEST_OTHER_KEY"}, "other"},
+		{BackendConfig{APIKeyFile: file}, "from-file"},
+		{BackendConfig{APIKeyCmd: "printf 'from-cmd\\nlogin: me\\n'"}, "from-cmd"},
+	} {
+		key, err := test.config.APIKey("TEST_DEFAULT_KEY")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != test.key {
+			t.Errorf("wanted %q, got %q", test.key, key)
+		}
+	}
+
+	for _, config := range []BackendConfig{
+		{APIKeyEnv: "TEST_UNSET_KEY"},
+		{APIKeyFile: file + ".missing"},
+		{APIKeyCmd: "exit 1"},
+		{APIKeyCmd: "true"},
+		{APIKeyEnv: "TEST_OTHER_KEY", APIKeyFile: file},
+	} {
+		if _, err := config.APIKey("TEST_DEFAULT_KEY"); err == nil {
+			t.Errorf("%+v: wanted error", config)
+		}
+	}
+}
+
+func writeFile(t *testing.T, name, text string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	user := writeFile(t, "ai.toml", `default_backend = "gpt"
+
+[backend.gpt]
+mode = "openai"
+model = "gpt-4o-mini"
+retry_timeout = "2m"
+
+[backend.gpt.options]
+temperature = 0.5
+
+[price."gpt-4o"]
+input = 2.5
+`)
+	project := writeFile(t, ".ai.toml", `default_backend = "local"
+
+[backend.gpt]
+model = "gpt-4o"
+`)
+	config, err := loadConfig(user, false, project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gpt := config.Backend["gpt"]
+	if gpt == nil || gpt.Mode != "openai" || gpt.Model != "gpt-4o" || gpt.RetryTimeout.Minutes() != 2 ||
+		gpt.Options.Temperature == nil || *gpt.Options.Temperature != 0.5 {
+		t.Fatalf("wanted gpt merged from both files, got %+v", gpt)
+	}
+	if config.DefaultBackend != "local" || config.Price["gpt-4o"] == nil || len(config.Files) != 2 {
+		t.Fatalf("wanted both files merged, got %+v", config)
+	}
+
+	// A missing config is only an error if requested explicitly.
+	missing := filepath.Join(t.TempDir(), "ai.toml")
+	if config, err := loadConfig(missing, false, ""); err != nil || len(config.Files) != 0 {
+		t.Fatalf("wanted empty config, got %+v, %v", config, err)
+	}
+	if _, err := loadConfig(missing, true, ""); err == nil {
+		t.Fatalf("wanted error for missing explicit config")
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		err  string
+	}{
+		{"[backend.x]\nmode = \"openai\"\nmodle = \"gpt-4o\"\n", `:3: unknown key backend.x.modle`},
+		{"default_backend = \"x\"\n\n[backnd.x]\nmode = \"openai\"\n", `:3: unknown key backnd`},
+		{"[backend.x.options]\ntemprature = 1\n", `:2: unknown key backend.x.options.temprature`},
+		{"[price.\"gpt-4o\"]\ninput = 1\nouput = 2\n", `:3: unknown key price.gpt-4o.ouput`},
+		{"[backend.x]\n  mode = \"openia\"\n", `:2: backend "x": invalid mode "openia"`},
+		{"[backend.x]\nmode = 1\n", `line 2`},
+	} {
+		path := writeFile(t, "ai.toml", test.text)
+		_, err := loadConfig(path, true, "")
+		if err == nil || !strings.Contains(err.Error(), path) || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: wanted error containing %q, got %v", test.text, test.err, err)
+		}
+	}
+
+}
+
+func TestProjectConfigRestricted(t *testing.T) {
+	user := writeFile(t, "ai.toml", "[backend.x]\nmode = \"openai\"\n")
+	ok := writeFile(t, ".ai.toml", "default_backend = \"x\"\n[backend.x]\nmodel = \"gpt-4o\"\n[backend.x.options]\ntemperature = 0\n")
+	if _, err := loadConfig(user, true, ok); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		text string
+		err  string
+	}{
+		{"[backend.x]\nurl = \"https://attacker.example/v1\"\n", ":2: backend.x.url"},
+		{"[backend.x]\napi_key_file = \"~/.ssh/id_rsa\"\n", ":2: backend.x.api_key_file"},
+		{"[backend.x]\napi_key_env = \"AWS_SECRET_ACCESS_KEY\"\n", ":2: backend.x.api_key_env"},
+		{"[backend.x]\napi_key_cmd = \"touch /tmp/pwned\"\n", ":2: backend.x.api_key_cmd"},
+		{"[backend.y]\nmode = \"openai\"\n", ":2: backend.y.mode"},
+		{"[tool.x]\ncommand = [\"sh\", \"-c\", \"touch /tmp/pwned\"]\n", ":1: tool"},
+		{"[cache]\nenabled = true\n", ":1: cache"},
+	} {
+		project := writeFile(t, ".ai.toml", test.text)
+		_, err := loadConfig(user, true, project)
+		if err == nil || !strings.Contains(err.Error(), project+test.err+" isn't allowed") {
+			t.Errorf("%q: wanted error containing %q, got %v", test.text, test.err, err)
+		}
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	for name, text := range map[string]string{
+		"ai.toml":         "include = [\"keys.toml\", \"sub/models.toml\"]\n\n[backend.gpt]\nmodel = \"gpt-4o\"\n",
+		"keys.toml":       "[backend.gpt]\nmode = \"openai\"\napi_key_env = \"WORK_KEY\"\nmodel = \"gpt-4o-mini\"\n",
+		"sub/models.toml": "include = [\"more.toml\"]\n",
+		"sub/more.toml":   "default_backend = \"gpt\"\n",
+		"cycle.toml":      "include = [\"cycle.toml\"]\n",
+		"missing.toml":    "include = [\"nope.toml\"]\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config, err := loadConfig(filepath.Join(dir, "ai.toml"), false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gpt := config.Backend["gpt"]
+	if gpt == nil || gpt.Mode != "openai" || gpt.APIKeyEnv != "WORK_KEY" || gpt.Model != "gpt-4o" || config.DefaultBackend != "gpt" {
+		t.Fatalf("wanted includes merged under ai.toml, got %+v %+v", config, gpt)
+	}
+	if len(config.Files) != 4 || len(config.Include) != 0 {
+		t.Fatalf("wanted 4 files and no includes left, got %v %v", config.Files, config.Include)
+	}
+
+	if _, err := loadConfig(filepath.Join(dir, "cycle.toml"), false, ""); err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("wanted include cycle error, got %v", err)
+	}
+	// A missing include is an error even when the main file is optional.
+	if _, err := loadConfig(filepath.Join(dir, "missing.toml"), false, ""); err == nil {
+		t.Errorf("wanted error for missing include")
+	}
+}
@@ -0,0 +1,74 @@
+package llm
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// APIError is an error reported by a backend's API, either as an HTTP error
+// response or in the middle of a stream.
+type APIError struct {
+	// Provider names the backend, e.g. "openai".
+	Provider string
+	// Status is the HTTP status code, or 0 for errors reported mid-stream.
+	Status int
+	// Type and Code are the provider's classification, when given,
+	// e.g. "insufficient_quota" or "RESOURCE_EXHAUSTED".
+	Type    string
+	Code    string
+	Message string
+	// RequestID identifies the request in the provider's logs, if known.
+	RequestID string
+	// RetryDelay is how long the provider asked us to wait, if it said.
+	RetryDelay time.Duration
+}
+
+// Snippet returns the start of an unparseable error body, e.g. a proxy's
+// HTML page, short enough for an error message.
+func Snippet(body []byte) string {
+	const max = 200
+	s := strings.TrimSpace(string(body))
+	if len(s) <= max {
+		return s
+	}
+	// Cut between runes.
+	n := max
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "..."
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = fmt.Sprintf("http status %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("%s: %s", e.Provider, msg)
+}
+
+// Retryable reports whether the same request might succeed if sent again.
+func (e *APIError) Retryable() bool {
+	switch e.Type {
+	case "insufficient_quota", "invalid_request_error", "INVALID_ARGUMENT", "PERMISSION_DENIED":
+		// Some of these come with a retryable-looking status code.
+		return false
+	case "server_error", "rate_limit_exceeded", "UNAVAILABLE", "INTERNAL", "DEADLINE_EXCEEDED",
+		"overloaded_error", "rate_limit_error", "api_error":
+		return true
+	case "RESOURCE_EXHAUSTED":
+		// Sent with a 429 for rate limits and exhausted quotas alike; only
+		// a rate limit says when to retry.
+		return e.RetryDelay > 0
+	}
+	switch {
+	case e.Status == http.StatusRequestTimeout, e.Status == http.StatusTooManyRequests:
+		return true
+	case e.Status >= 500:
+		return true
+	}
+	return false
+}
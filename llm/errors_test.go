@@ -0,0 +1,37 @@
+package llm
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestSnippet(t *testing.T) {
+	if s := Snippet([]byte("  <html>bad gateway</html>\n")); s != "<html>bad gateway</html>" {
+		t.Fatalf("wanted trimmed body, got %q", s)
+	}
+	// 199 bytes then a 3-byte rune straddling the cut.
+	body := strings.Repeat("a", 199) + "€€"
+	s := Snippet([]byte(body))
+	if !utf8.ValidString(s) || s != strings.Repeat("a", 199)+"..." {
+		t.Fatalf("wanted cut before the rune, got %q", s)
+	}
+}
+
+func TestRetryableResourceExhausted(t *testing.T) {
+	for _, test := range []struct {
+		err  *APIError
+		want bool
+	}{
+		{&APIError{Type: "RESOURCE_EXHAUSTED", Status: 429, RetryDelay: 30 * time.Second}, true},
+		{&APIError{Type: "RESOURCE_EXHAUSTED", RetryDelay: 30 * time.Second}, true},
+		// Nothing says waiting will help, e.g. a daily quota.
+		{&APIError{Type: "RESOURCE_EXHAUSTED", Status: 429}, false},
+		{&APIError{Type: "RESOURCE_EXHAUSTED"}, false},
+	} {
+		if got := test.err.Retryable(); got != test.want {
+			t.Errorf("%+v: wanted retryable=%v, got %v", test.err, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Package ledger keeps a local record of every call's usage, for totting up
+// costs over time.
+package ledger
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Record is one call's entry in the ledger.
+type Record struct {
+	Time         time.Time `json:"time"`
+	Backend      string    `json:"backend"`
+	Model        string    `json:"model,omitempty"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	LatencyMs    int64     `json:"latency_ms"`
+	// Status is "ok", or the error that ended the call.
+	Status string `json:"status"`
+	// Cost is the estimated dollar cost, if the model's price is known.
+	Cost float64 `json:"cost,omitempty"`
+}
+
+// Path returns the ledger file's location.
+func Path() string {
+	data := os.Getenv("XDG_DATA_HOME")
+	if data == "" {
+		data = os.ExpandEnv("$HOME/.local/share")
+	}
+	return filepath.Join(data, "ai", "usage.jsonl")
+}
+
+// Append adds r to the end of the ledger.
+func Append(r *Record) error {
+	buf, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(Path()), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(Path(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	// One write per record, so concurrent processes don't interleave lines.
+	if _, err := f.Write(append(buf, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// warnings receives notes about records Read skips.
+var warnings io.Writer = os.Stderr
+
+// Read returns the records made at or after since, oldest first.  Lines
+// that don't parse, e.g. from a crash mid-append, are skipped with a
+// warning so one bad record doesn't block every budget check.
+func Read(since time.Time) ([]*Record, error) {
+	f, err := os.Open(Path())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []*Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			fmt.Fprintf(warnings, "warning: %s:%d: skipping bad record: %s\n", Path(), line, err)
+			continue
+		}
+		if !r.Time.Before(since) {
+			records = append(records, &r)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// Total aggregates a group of records.
+type Total struct {
+	Key          string
+	Calls        int
+	Errors       int
+	InputTokens  int
+	OutputTokens int
+	Cost         float64
+	// Latency is the total across calls.
+	Latency time.Duration
+}
+
+// Summarize groups records by key, sorted by key.
+func Summarize(records []*Record, key func(*Record) string) []*Total {
+	byKey := map[string]*Total{}
+	for _, r := range records {
+		k := key(r)
+		t := byKey[k]
+		if t == nil {
+			t = &Total{Key: k}
+			byKey[k] = t
+		}
+		t.Calls++
+		if r.Status != "ok" {
+			t.Errors++
+		}
+		t.InputTokens += r.InputTokens
+		t.OutputTokens += r.OutputTokens
+		t.Cost += r.Cost
+		t.Latency += time.Duration(r.LatencyMs) * time.Millisecond
+	}
+	totals := make([]*Total, 0, len(byKey))
+	for _, t := range byKey {
+		totals = append(totals, t)
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Key < totals[j].Key })
+	return totals
+}
+
+// Spent returns the total cost of records at or after since.
+func Spent(records []*Record, since time.Time) float64 {
+	var cost float64
+	for _, r := range records {
+		if !r.Time.Before(since) {
+			cost += r.Cost
+		}
+	}
+	return cost
+}
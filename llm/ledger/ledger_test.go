@@ -0,0 +1,89 @@
+package ledger
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLedger(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	records, err := Read(time.Time{})
+	if err != nil || len(records) != 0 {
+		t.Fatalf("wanted empty ledger, got %v, %v", records, err)
+	}
+
+	day := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	for _, r := range []*Record{
+		{Time: day.AddDate(0, 0, -1), Backend: "openai", Model: "gpt-4o", InputTokens: 10, OutputTokens: 5, Status: "ok", Cost: 1},
+		{Time: day, Backend: "openai", Model: "gpt-4o", InputTokens: 20, OutputTokens: 5, Status: "ok", Cost: 2},
+		{Time: day, Backend: "llama", InputTokens: 7, OutputTokens: 3, Status: "ollama: model not found"},
+	} {
+		if err := Append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err = Read(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("wanted 2 records since %v, got %d", day, len(records))
+	}
+
+	all, err := Read(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	totals := Summarize(all, func(r *Record) string { return r.Backend })
+	if len(totals) != 2 || totals[0].Key != "llama" || totals[1].Key != "openai" {
+		t.Fatalf("wanted llama and openai totals, got %+v", totals)
+	}
+	if l := totals[0]; l.Calls != 1 || l.Errors != 1 {
+		t.Errorf("wanted 1 failed llama call, got %+v", l)
+	}
+	if o := totals[1]; o.Calls != 2 || o.InputTokens != 30 || o.OutputTokens != 10 || o.Cost != 3 {
+		t.Errorf("wrong openai totals: %+v", o)
+	}
+
+	if spent := Spent(all, day); spent != 2 {
+		t.Errorf("wanted $2 spent since %v, got %v", day, spent)
+	}
+}
+
+func TestReadSkipsBadLines(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	var warned bytes.Buffer
+	warnings = &warned
+	t.Cleanup(func() { warnings = os.Stderr })
+
+	day := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	if err := Append(&Record{Time: day, Backend: "openai", Status: "ok", Cost: 1}); err != nil {
+		t.Fatal(err)
+	}
+	// A record cut off by a crash, then one more good one.
+	f, err := os.OpenFile(Path(), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":"2025-01-15T12:00:00Z","back` + "\n")
+	f.Close()
+	if err := Append(&Record{Time: day, Backend: "openai", Status: "ok", Cost: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := Read(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || Spent(records, day) != 3 {
+		t.Fatalf("wanted the 2 good records, got %+v", records)
+	}
+	if !strings.Contains(warned.String(), "usage.jsonl:2: skipping bad record") {
+		t.Fatalf("wanted a warning for line 2, got %q", warned.String())
+	}
+}
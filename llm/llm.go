@@ -1,27 +1,164 @@
 package llm
 
-import "github.com/evmar/ai/image"
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+)
 
-// TODO: unify streaming with Call interface
+type Prompt struct {
+	System string
+	JSON   bool
+	// Schema, if set, is a JSON schema the output should conform to.
+	// It implies JSON.
+	Schema   json.RawMessage
+	Messages []*Message
+	Tools    []*Tool
+	// Options override the backend's configured options.
+	Options Options
+}
 
-type Stream interface {
-	Next() (string, error)
+// Event is one item produced by a Stream: a *TextDelta, *ToolCall, *Finish,
+// or *Usage.
+type Event interface {
+	isEvent()
 }
 
-type Streamed interface {
-	CallStreamed(sys string, json bool, msgs []string) (Stream, error)
+// TextDelta is a chunk of generated text.
+type TextDelta struct {
+	Text string
 }
 
-type Message interface{}
+// Finish reports why the model stopped, in the backend's own terms
+// (e.g. "stop", "length", "STOP").
+type Finish struct {
+	Reason string
+}
 
-type Prompt struct {
-	System   string
-	JSON     bool
-	Messages []string
-	Images   []*image.LoadedImage
+// Usage reports token counts for the call.
+type Usage struct {
+	InputTokens  int
+	OutputTokens int
+}
+
+func (*TextDelta) isEvent() {}
+func (*Finish) isEvent()    {}
+func (*Usage) isEvent()     {}
+
+// Stream is the result of a call.  Next returns io.EOF after the last event;
+// any other error (transport or provider) ends the stream.
+type Stream interface {
+	Next() (Event, error)
+	Close() error
 }
 
 type LLM interface {
-	// TODO: streaming only
-	Call(prompt *Prompt) (string, error)
+	Call(ctx context.Context, prompt *Prompt) (Stream, error)
+}
+
+// Model describes a model offered by a backend.
+type Model struct {
+	Name string
+	// ContextLength is the context window in tokens, or 0 if unknown.
+	ContextLength int
+}
+
+// Response is a whole stream gathered together.
+type Response struct {
+	Text         string
+	ToolCalls    []*ToolCall
+	FinishReason string
+	Usage        *Usage
+}
+
+// Message returns the response as an assistant message.
+func (r *Response) Message() *Message {
+	msg := &Message{Role: RoleAssistant}
+	if r.Text != "" {
+		msg.Parts = append(msg.Parts, &Text{Text: r.Text})
+	}
+	for _, call := range r.ToolCalls {
+		msg.Parts = append(msg.Parts, call)
+	}
+	return msg
+}
+
+// ReadResponse reads a stream to the end and gathers its events.
+func ReadResponse(stream Stream) (*Response, error) {
+	defer stream.Close()
+	resp := &Response{}
+	var text strings.Builder
+	for {
+		ev, err := stream.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			resp.Text = text.String()
+			return resp, err
+		}
+		switch ev := ev.(type) {
+		case *TextDelta:
+			text.WriteString(ev.Text)
+		case *ToolCall:
+			resp.ToolCalls = append(resp.ToolCalls, ev)
+		case *Finish:
+			resp.FinishReason = ev.Reason
+		case *Usage:
+			resp.Usage = ev
+		}
+	}
+	resp.Text = text.String()
+	return resp, nil
+}
+
+// Collect reads a stream to the end and returns the concatenated text.
+func Collect(stream Stream) (string, error) {
+	resp, err := ReadResponse(stream)
+	return resp.Text, err
+}
+
+type staticStream struct {
+	events []Event
+}
+
+// StaticStream returns a Stream that yields the given events, for backends
+// that produce the whole response at once.
+func StaticStream(events ...Event) Stream {
+	return &staticStream{events: events}
+}
+
+func (s *staticStream) Next() (Event, error) {
+	if len(s.events) == 0 {
+		return nil, io.EOF
+	}
+	ev := s.events[0]
+	s.events = s.events[1:]
+	return ev, nil
+}
+
+func (s *staticStream) Close() error {
+	return nil
+}
+
+type observer struct {
+	stream Stream
+	fn     func(Event)
+}
+
+// Observe returns a Stream that passes each event to fn as it is read.
+func Observe(stream Stream, fn func(Event)) Stream {
+	return &observer{stream: stream, fn: fn}
+}
+
+func (o *observer) Next() (Event, error) {
+	ev, err := o.stream.Next()
+	if err == nil {
+		o.fn(ev)
+	}
+	return ev, err
+}
+
+func (o *observer) Close() error {
+	return o.stream.Close()
 }
@@ -0,0 +1,37 @@
+// Package llmtest provides a deterministic llm.LLM for tests.
+package llmtest
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/evmar/ai/llm"
+)
+
+// Fake replays scripted responses in order, recording each prompt it sees.
+type Fake struct {
+	// Responses holds the events to return for each successive call.
+	Responses [][]llm.Event
+	// Prompts records a copy of each prompt passed to Call.
+	Prompts []*llm.Prompt
+}
+
+var _ llm.LLM = (*Fake)(nil)
+
+// Text returns a response consisting of a single text event.
+func Text(text string) []llm.Event {
+	return []llm.Event{&llm.TextDelta{Text: text}, &llm.Finish{Reason: "stop"}}
+}
+
+func (f *Fake) Call(ctx context.Context, prompt *llm.Prompt) (llm.Stream, error) {
+	p := *prompt
+	p.Messages = append([]*llm.Message(nil), prompt.Messages...)
+	f.Prompts = append(f.Prompts, &p)
+
+	if len(f.Responses) == 0 {
+		return nil, fmt.Errorf("llmtest: no response scripted for call %d", len(f.Prompts))
+	}
+	events := f.Responses[0]
+	f.Responses = f.Responses[1:]
+	return llm.StaticStream(events...), nil
+}
@@ -0,0 +1,203 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+	RoleTool      Role = "tool"
+)
+
+// Message is one turn of a conversation.
+type Message struct {
+	Role  Role
+	Parts []Part
+}
+
+// Part is one piece of a message's content: a *Text, *Image, *Audio, *File,
+// *ToolCall, or *ToolResult.
+type Part interface {
+	isPart()
+}
+
+type Text struct {
+	Text string
+}
+
+type Image struct {
+	MimeType string
+	Data     []byte
+}
+
+type Audio struct {
+	MimeType string
+	Data     []byte
+}
+
+// File is an arbitrary document, such as a PDF.
+type File struct {
+	Name     string
+	MimeType string
+	Data     []byte
+}
+
+func (*Text) isPart()       {}
+func (*Image) isPart()      {}
+func (*Audio) isPart()      {}
+func (*File) isPart()       {}
+func (*ToolCall) isPart()   {}
+func (*ToolResult) isPart() {}
+
+// TextMessage returns a message consisting of a single text part.
+func TextMessage(role Role, text string) *Message {
+	return &Message{Role: role, Parts: []Part{&Text{Text: text}}}
+}
+
+// Alternating builds a conversation from texts alternating between user and
+// assistant, starting with the user.
+func Alternating(texts ...string) []*Message {
+	var msgs []*Message
+	for i, text := range texts {
+		role := RoleUser
+		if i%2 != 0 {
+			role = RoleAssistant
+		}
+		msgs = append(msgs, TextMessage(role, text))
+	}
+	return msgs
+}
+
+// Text returns the concatenation of the message's text parts.
+func (m *Message) Text() string {
+	var text strings.Builder
+	for _, part := range m.Parts {
+		if t, ok := part.(*Text); ok {
+			text.WriteString(t.Text)
+		}
+	}
+	return text.String()
+}
+
+// ToolCalls returns the message's tool call parts.
+func (m *Message) ToolCalls() []*ToolCall {
+	var calls []*ToolCall
+	for _, part := range m.Parts {
+		if call, ok := part.(*ToolCall); ok {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func loadMedia(path string) (string, []byte, error) {
+	mimeType := mime.TypeByExtension(filepath.Ext(path))
+	if mimeType == "" {
+		return "", nil, fmt.Errorf("%s: unknown file type", path)
+	}
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return mimeType, data, nil
+}
+
+// LoadAudio reads an audio file, e.g. a .wav or .mp3.
+func LoadAudio(path string) (*Audio, error) {
+	mimeType, data, err := loadMedia(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(mimeType, "audio/") {
+		return nil, fmt.Errorf("%s: not an audio file (%s)", path, mimeType)
+	}
+	return &Audio{MimeType: mimeType, Data: data}, nil
+}
+
+// LoadFile reads a document to attach, e.g. a .pdf.
+func LoadFile(path string) (*File, error) {
+	mimeType, data, err := loadMedia(path)
+	if err != nil {
+		return nil, err
+	}
+	return &File{Name: filepath.Base(path), MimeType: mimeType, Data: data}, nil
+}
+
+// jsonPart is the serialized form of a Part, tagged by type.
+type jsonPart struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	MimeType  string          `json:"mime_type,omitempty"`
+	Data      []byte          `json:"data,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type jsonMessage struct {
+	Role  Role       `json:"role"`
+	Parts []jsonPart `json:"parts"`
+}
+
+func (m *Message) MarshalJSON() ([]byte, error) {
+	jm := jsonMessage{Role: m.Role, Parts: []jsonPart{}}
+	for _, part := range m.Parts {
+		var jp jsonPart
+		switch part := part.(type) {
+		case *Text:
+			jp = jsonPart{Type: "text", Text: part.Text}
+		case *Image:
+			jp = jsonPart{Type: "image", MimeType: part.MimeType, Data: part.Data}
+		case *Audio:
+			jp = jsonPart{Type: "audio", MimeType: part.MimeType, Data: part.Data}
+		case *File:
+			jp = jsonPart{Type: "file", Name: part.Name, MimeType: part.MimeType, Data: part.Data}
+		case *ToolCall:
+			jp = jsonPart{Type: "tool_call", ID: part.ID, Name: part.Name, Arguments: part.Arguments}
+		case *ToolResult:
+			jp = jsonPart{Type: "tool_result", ID: part.ID, Name: part.Name, Content: part.Content}
+		}
+		jm.Parts = append(jm.Parts, jp)
+	}
+	return json.Marshal(jm)
+}
+
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var jm jsonMessage
+	if err := json.Unmarshal(data, &jm); err != nil {
+		return err
+	}
+	m.Role = jm.Role
+	m.Parts = nil
+	for _, jp := range jm.Parts {
+		var part Part
+		switch jp.Type {
+		case "text":
+			part = &Text{Text: jp.Text}
+		case "image":
+			part = &Image{MimeType: jp.MimeType, Data: jp.Data}
+		case "audio":
+			part = &Audio{MimeType: jp.MimeType, Data: jp.Data}
+		case "file":
+			part = &File{Name: jp.Name, MimeType: jp.MimeType, Data: jp.Data}
+		case "tool_call":
+			part = &ToolCall{ID: jp.ID, Name: jp.Name, Arguments: jp.Arguments}
+		case "tool_result":
+			part = &ToolResult{ID: jp.ID, Name: jp.Name, Content: jp.Content}
+		default:
+			return fmt.Errorf("unknown message part type %q", jp.Type)
+		}
+		m.Parts = append(m.Parts, part)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package llm
+
+// Options are generation parameters.  Unset (nil or empty) fields are left
+// to the backend's default.
+type Options struct {
+	Temperature *float64 `toml:"temperature,omitempty"`
+	MaxTokens   *int     `toml:"max_tokens,omitempty"`
+	TopP        *float64 `toml:"top_p,omitempty"`
+	Stop        []string `toml:"stop,omitempty"`
+	Seed        *int     `toml:"seed,omitempty"`
+	// ReasoningEffort is "low", "medium" or "high", for reasoning models.
+	ReasoningEffort string `toml:"reasoning_effort,omitempty"`
+}
+
+// Merge returns o with any fields set in override taking precedence.
+func (o Options) Merge(override Options) Options {
+	if override.Temperature != nil {
+		o.Temperature = override.Temperature
+	}
+	if override.MaxTokens != nil {
+		o.MaxTokens = override.MaxTokens
+	}
+	if override.TopP != nil {
+		o.TopP = override.TopP
+	}
+	if override.Stop != nil {
+		o.Stop = override.Stop
+	}
+	if override.Seed != nil {
+		o.Seed = override.Seed
+	}
+	if override.ReasoningEffort != "" {
+		o.ReasoningEffort = override.ReasoningEffort
+	}
+	return o
+}
@@ -0,0 +1,375 @@
+// Package schema validates JSON values against a JSON Schema.
+//
+// Only the commonly used subset of keywords is supported: type, enum, const,
+// properties, required, additionalProperties, items, prefixItems, minItems,
+// maxItems, minLength, maxLength, minimum, maximum, anyOf.  Annotations like
+// description are allowed; any other keyword, like $ref or pattern, is an
+// error rather than silently not checked.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"unicode/utf8"
+)
+
+// Schema is a parsed JSON schema.
+type Schema struct {
+	Type       interface{} // string or []string
+	Enum       []interface{}
+	Const      interface{}
+	Properties map[string]*Schema
+	Required   []string
+	// AdditionalProperties checks properties not in Properties; nil allows
+	// any.
+	AdditionalProperties *Schema
+	// PrefixItems checks the first items of an array, from prefixItems or
+	// the older tuple form of items; Items checks the rest.
+	PrefixItems []*Schema
+	Items       *Schema
+	MinItems    *int
+	MaxItems    *int
+	MinLength   *int
+	MaxLength   *int
+	Minimum     *float64
+	Maximum     *float64
+	AnyOf       []*Schema
+
+	// Never is set for the schema false, which nothing conforms to.
+	Never bool
+
+	// Raw is the schema as given, for sending to backends.
+	Raw json.RawMessage
+}
+
+// annotations are keywords that don't affect validation.
+var annotations = map[string]bool{
+	"$schema":     true,
+	"$id":         true,
+	"$comment":    true,
+	"title":       true,
+	"description": true,
+	"default":     true,
+	"examples":    true,
+}
+
+func Parse(raw []byte) (*Schema, error) {
+	s, err := parse("#", raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing schema: %w", err)
+	}
+	s.Raw = json.RawMessage(raw)
+	return s, nil
+}
+
+// parse parses the schema at path, a JSON pointer like "#/properties/name".
+func parse(path string, raw json.RawMessage) (*Schema, error) {
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		// true and false are schemas accepting everything and nothing.
+		return &Schema{Never: !b}, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	s := &Schema{}
+	for _, key := range keys {
+		val := fields[key]
+		sub := path + "/" + key
+		var err error
+		switch key {
+		case "type":
+			err = json.Unmarshal(val, &s.Type)
+		case "enum":
+			err = json.Unmarshal(val, &s.Enum)
+		case "const":
+			err = json.Unmarshal(val, &s.Const)
+		case "required":
+			err = json.Unmarshal(val, &s.Required)
+		case "minItems":
+			err = json.Unmarshal(val, &s.MinItems)
+		case "maxItems":
+			err = json.Unmarshal(val, &s.MaxItems)
+		case "minLength":
+			err = json.Unmarshal(val, &s.MinLength)
+		case "maxLength":
+			err = json.Unmarshal(val, &s.MaxLength)
+		case "minimum":
+			err = json.Unmarshal(val, &s.Minimum)
+		case "maximum":
+			err = json.Unmarshal(val, &s.Maximum)
+		case "properties":
+			var props map[string]json.RawMessage
+			if err = json.Unmarshal(val, &props); err != nil {
+				break
+			}
+			s.Properties = map[string]*Schema{}
+			for name, prop := range props {
+				if s.Properties[name], err = parse(sub+"/"+name, prop); err != nil {
+					return nil, err
+				}
+			}
+		case "additionalProperties":
+			if s.AdditionalProperties, err = parse(sub, val); err != nil {
+				return nil, err
+			}
+		case "items":
+			if bytes.HasPrefix(bytes.TrimSpace(val), []byte("[")) {
+				s.PrefixItems, err = parseList(sub, val)
+			} else {
+				s.Items, err = parse(sub, val)
+			}
+			if err != nil {
+				return nil, err
+			}
+		case "prefixItems":
+			if s.PrefixItems, err = parseList(sub, val); err != nil {
+				return nil, err
+			}
+		case "anyOf":
+			if s.AnyOf, err = parseList(sub, val); err != nil {
+				return nil, err
+			}
+		default:
+			if !annotations[key] {
+				return nil, fmt.Errorf("%s: unsupported keyword %q", path, key)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", sub, err)
+		}
+	}
+	return s, nil
+}
+
+// parseList parses an array of schemas.
+func parseList(path string, raw json.RawMessage) ([]*Schema, error) {
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var schemas []*Schema
+	for i, item := range list {
+		s, err := parse(fmt.Sprintf("%s/%d", path, i), item)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas, nil
+}
+
+func Load(path string) (*Schema, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Error describes where a value failed to conform.
+type Error struct {
+	// Path is a JSONPath-like location, e.g. "$.args[0].name".
+	Path    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// Validate checks that data is JSON conforming to the schema.
+func (s *Schema) Validate(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return &Error{Path: "$", Message: fmt.Sprintf("invalid JSON: %s", err)}
+	}
+	if dec.More() {
+		return &Error{Path: "$", Message: "trailing data after JSON value"}
+	}
+	return s.validate("$", v)
+}
+
+// typeOf returns the JSON schema type name of a decoded value.
+func typeOf(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		// As in JSON Schema, 1.0 is an integer too.
+		if f, err := v.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func (s *Schema) types() []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		var ts []string
+		for _, t := range t {
+			if t, ok := t.(string); ok {
+				ts = append(ts, t)
+			}
+		}
+		return ts
+	}
+	return nil
+}
+
+func typeMatches(want, got string) bool {
+	return want == got || (want == "number" && got == "integer")
+}
+
+// equal compares decoded values, treating numbers by value.
+func equal(a, b interface{}) bool {
+	if n, ok := a.(json.Number); ok {
+		f, _ := n.Float64()
+		g, ok := b.(float64)
+		return ok && f == g
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func (s *Schema) validate(path string, v interface{}) error {
+	errorf := func(format string, args ...interface{}) error {
+		return &Error{Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+	if s.Never {
+		return errorf("no value is allowed here")
+	}
+
+	if ts := s.types(); len(ts) > 0 {
+		got := typeOf(v)
+		ok := false
+		for _, t := range ts {
+			if typeMatches(t, got) {
+				ok = true
+			}
+		}
+		if !ok {
+			return errorf("expected %s, got %s", s.Type, got)
+		}
+	}
+	if s.Enum != nil {
+		ok := false
+		for _, e := range s.Enum {
+			if equal(v, e) {
+				ok = true
+			}
+		}
+		if !ok {
+			return errorf("value not in enum %v", s.Enum)
+		}
+	}
+	if s.Const != nil && !equal(v, s.Const) {
+		return errorf("expected const %v", s.Const)
+	}
+	if s.AnyOf != nil {
+		ok := false
+		for _, sub := range s.AnyOf {
+			if sub.validate(path, v) == nil {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return errorf("doesn't match any of anyOf")
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, key := range s.Required {
+			if _, ok := v[key]; !ok {
+				return errorf("missing required property %q", key)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			sub, ok := s.Properties[key]
+			if !ok {
+				sub = s.AdditionalProperties
+				if sub == nil {
+					continue
+				}
+				if sub.Never {
+					return errorf("unexpected property %q", key)
+				}
+			}
+			if err := sub.validate(fmt.Sprintf("%s.%s", path, key), v[key]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			return errorf("expected at least %d items, got %d", *s.MinItems, len(v))
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return errorf("expected at most %d items, got %d", *s.MaxItems, len(v))
+		}
+		for i, item := range v {
+			sub := s.Items
+			if i < len(s.PrefixItems) {
+				sub = s.PrefixItems[i]
+			}
+			if sub == nil {
+				continue
+			}
+			if err := sub.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength {
+			return errorf("expected at least %d characters, got %d", *s.MinLength, n)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return errorf("expected at most %d characters, got %d", *s.MaxLength, n)
+		}
+	case json.Number:
+		f, _ := v.Float64()
+		if s.Minimum != nil && f < *s.Minimum {
+			return errorf("%v is less than minimum %v", f, *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			return errorf("%v is greater than maximum %v", f, *s.Maximum)
+		}
+	}
+	return nil
+}
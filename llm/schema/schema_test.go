@@ -0,0 +1,116 @@
+package schema
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	s, err := Parse([]byte(`{
+		"type": "object",
+		"properties": {
+			"return_type": {"type": "string"},
+			"name": {"type": "string", "minLength": 1},
+			"kind": {"enum": ["func", "method"]},
+			"arity": {"type": "integer", "minimum": 0},
+			"args": {
+				"type": "array",
+				"maxItems": 2,
+				"items": {
+					"type": "object",
+					"properties": {"name": {"type": "string"}},
+					"required": ["name"]
+				}
+			}
+		},
+		"required": ["return_type", "name"],
+		"additionalProperties": false
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{`{"return_type": "int", "name": "main"}`, ""},
+		{`{"return_type": "int", "name": "f", "kind": "func", "arity": 1, "args": [{"name": "x"}]}`, ""},
+		{`{"return_type": "int"}`, `$: missing required property "name"`},
+		{`{"return_type": 3, "name": "main"}`, `$.return_type: expected string, got integer`},
+		{`{"return_type": "int", "name": ""}`, `$.name: expected at least 1 characters, got 0`},
+		{`{"return_type": "int", "name": "f", "kind": "lambda"}`, `$.kind: value not in enum [func method]`},
+		{`{"return_type": "int", "name": "f", "arity": 1.5}`, `$.arity: expected integer, got number`},
+		{`{"return_type": "int", "name": "f", "arity": -1}`, `$.arity: -1 is less than minimum 0`},
+		{`{"return_type": "int", "name": "f", "args": [{}]}`, `$.args[0]: missing required property "name"`},
+		{`{"return_type": "int", "name": "f", "args": [{"name": "a"}, {"name": "b"}, {"name": "c"}]}`, `$.args: expected at most 2 items, got 3`},
+		{`{"return_type": "int", "name": "f", "extra": 1}`, `$: unexpected property "extra"`},
+		{`[]`, `$: expected object, got array`},
+		{`{"return_type": "int", "name": "main"`, `$: invalid JSON: unexpected EOF`},
+		{`{"return_type": "int", "name": "main"} {}`, `$: trailing data after JSON value`},
+	}
+	for _, test := range tests {
+		err := s.Validate([]byte(test.data))
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != test.err {
+			t.Errorf("%s:\nwanted %q\ngot    %q", test.data, test.err, got)
+		}
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	for _, test := range []struct {
+		schema string
+		err    string
+	}{
+		{`{"$ref": "#/$defs/a", "$defs": {"a": {"type": "string"}}}`, `#: unsupported keyword "$defs"`},
+		{`{"properties": {"a": {"type": "string", "pattern": "^x"}}}`, `#/properties/a: unsupported keyword "pattern"`},
+		{`{"items": {"oneOf": [{"type": "string"}]}}`, `#/items: unsupported keyword "oneOf"`},
+		{`{"anyOf": [{"format": "date"}]}`, `#/anyOf/0: unsupported keyword "format"`},
+		{`{"allOf": []}`, `#: unsupported keyword "allOf"`},
+		{`{"minimum": "x"}`, `#/minimum: json: cannot unmarshal string into Go value of type float64`},
+	} {
+		_, err := Parse([]byte(test.schema))
+		if err == nil || err.Error() != "parsing schema: "+test.err {
+			t.Errorf("%s:\nwanted %q\ngot    %v", test.schema, test.err, err)
+		}
+	}
+}
+
+func TestSubschemas(t *testing.T) {
+	s, err := Parse([]byte(`{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"description": "a point and its labels",
+		"type": "object",
+		"properties": {
+			"point": {"type": "array", "items": [{"type": "number"}, {"type": "integer"}]},
+			"next": {"type": "array", "prefixItems": [{"type": "string"}], "items": {"type": "integer"}}
+		},
+		"additionalProperties": {"type": "string"}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{`{"point": [1.5, 2], "label": "a"}`, ""},
+		{`{"point": [1.5, 2.0]}`, ""},
+		{`{"point": [1.5, 2.5]}`, `$.point[1]: expected integer, got number`},
+		{`{"next": ["a", 1, 2]}`, ""},
+		{`{"next": ["a", "b"]}`, `$.next[1]: expected integer, got string`},
+		{`{"label": 1}`, `$.label: expected string, got integer`},
+	}
+	for _, test := range tests {
+		err := s.Validate([]byte(test.data))
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != test.err {
+			t.Errorf("%s:\nwanted %q\ngot    %q", test.data, test.err, got)
+		}
+	}
+}
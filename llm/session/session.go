@@ -0,0 +1,148 @@
+// Package session persists conversations so later calls can resume them.
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/evmar/ai/llm"
+)
+
+type Session struct {
+	Name     string         `json:"name"`
+	Backend  string         `json:"backend,omitempty"`
+	Model    string         `json:"model,omitempty"`
+	System   string         `json:"system,omitempty"`
+	Messages []*llm.Message `json:"messages"`
+	Created  time.Time      `json:"created"`
+	Updated  time.Time      `json:"updated"`
+}
+
+// Dir returns the directory holding saved sessions.
+func Dir() string {
+	data := os.Getenv("XDG_DATA_HOME")
+	if data == "" {
+		data = os.ExpandEnv("$HOME/.local/share")
+	}
+	return filepath.Join(data, "ai", "sessions")
+}
+
+func checkName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid session name %q", name)
+	}
+	return nil
+}
+
+func path(name string) string {
+	return filepath.Join(Dir(), name+".json")
+}
+
+// New returns an empty, unsaved session.
+func New(name string) (*Session, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	return &Session{Name: name, Created: time.Now()}, nil
+}
+
+func Load(name string) (*Session, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	buf, err := os.ReadFile(path(name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no session %q", name)
+		}
+		return nil, err
+	}
+	var s Session
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return nil, fmt.Errorf("session %q: %w", name, err)
+	}
+	return &s, nil
+}
+
+// LoadOrNew loads the named session, or starts a new one if it doesn't exist.
+func LoadOrNew(name string) (*Session, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path(name)); errors.Is(err, os.ErrNotExist) {
+		return New(name)
+	}
+	return Load(name)
+}
+
+func (s *Session) Save() error {
+	s.Updated = time.Now()
+	buf, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(Dir(), 0700); err != nil {
+		return err
+	}
+	// Write then rename, so an interrupted save doesn't lose the history.
+	tmp := path(s.Name) + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path(s.Name))
+}
+
+// List returns the names of saved sessions, sorted.
+func List() ([]string, error) {
+	entries, err := os.ReadDir(Dir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if name, ok := strings.CutSuffix(e.Name(), ".json"); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func Remove(name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	err := os.Remove(path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no session %q", name)
+	}
+	return err
+}
+
+// Fork copies session src to a new session dst.
+func Fork(src, dst string) (*Session, error) {
+	s, err := Load(src)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkName(dst); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path(dst)); err == nil {
+		return nil, fmt.Errorf("session %q already exists", dst)
+	}
+	s.Name = dst
+	s.Created = time.Now()
+	if err := s.Save(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
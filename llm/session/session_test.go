@@ -0,0 +1,78 @@
+package session
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/evmar/ai/llm"
+)
+
+func TestSaveLoad(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	s, err := LoadOrNew("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Backend = "llama"
+	s.System = "be terse"
+	s.Messages = []*llm.Message{
+		{Role: llm.RoleUser, Parts: []llm.Part{
+			&llm.Image{MimeType: "image/png", Data: []byte("png")},
+			&llm.Text{Text: "what's this?"},
+		}},
+		{Role: llm.RoleAssistant, Parts: []llm.Part{
+			&llm.ToolCall{ID: "1", Name: "look", Arguments: json.RawMessage(`{"x":1}`)},
+		}},
+		{Role: llm.RoleTool, Parts: []llm.Part{
+			&llm.ToolResult{ID: "1", Name: "look", Content: "a cat"},
+		}},
+	}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Backend != "llama" || loaded.System != "be terse" {
+		t.Errorf("wanted backend/system restored, got %q/%q", loaded.Backend, loaded.System)
+	}
+	// Compare serialized, since saving reformats tool call arguments.
+	want, _ := json.Marshal(s.Messages)
+	got, _ := json.Marshal(loaded.Messages)
+	if string(got) != string(want) {
+		t.Errorf("messages didn't round trip:\nwanted %s\ngot    %s", want, got)
+	}
+
+	if _, err := Fork("test", "copy"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Fork("test", "copy"); err == nil {
+		t.Errorf("wanted error forking onto existing session")
+	}
+	names, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"copy", "test"}) {
+		t.Errorf("wanted [copy test], got %v", names)
+	}
+
+	if err := Remove("test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load("test"); err == nil {
+		t.Errorf("wanted error loading removed session")
+	}
+}
+
+func TestBadName(t *testing.T) {
+	for _, name := range []string{"", "../x", "a/b", ".hidden"} {
+		if _, err := New(name); err == nil {
+			t.Errorf("wanted error for name %q", name)
+		}
+	}
+}
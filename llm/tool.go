@@ -0,0 +1,49 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Tool declares a function the model may call.
+type Tool struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Parameters is a JSON schema for the arguments object.
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+}
+
+// LoadTool reads a tool declaration from a JSON file like data/myfunc.json.
+func LoadTool(path string) (*Tool, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tool Tool
+	if err := json.Unmarshal(buf, &tool); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if tool.Name == "" {
+		return nil, fmt.Errorf("%s: tool needs a name", path)
+	}
+	return &tool, nil
+}
+
+// ToolCall is an event requesting a call to one of the prompt's tools.
+type ToolCall struct {
+	// ID identifies the call, for backends that use one to match results.
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+func (*ToolCall) isEvent() {}
+
+// ToolResult is the output of a ToolCall, sent back to the model.
+type ToolResult struct {
+	// ID and Name match the originating ToolCall.
+	ID      string
+	Name    string
+	Content string
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/evmar/ai/llm"
+)
+
+// ModelLister is implemented by backends that can list their models.
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]*llm.Model, error)
+}
+
+// runModels lists the models of the named backends, or of -backend, or of
+// all configured backends.
+func runModels(ctx context.Context, config *llm.Config, names []string) error {
+	if len(names) == 0 && *flagBackend != "" {
+		names = []string{*flagBackend}
+	}
+	if len(names) == 0 {
+		for name := range config.Backend {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "backend\tmodel\tcontext\t\n")
+	var failed []string
+	for _, name := range names {
+		models, err := listModels(ctx, config, name)
+		if err != nil {
+			// Keep going, as one backend being down or missing a key
+			// shouldn't hide the others.
+			fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+			failed = append(failed, name)
+			continue
+		}
+		for _, m := range models {
+			contextLen := ""
+			if m.ContextLength > 0 {
+				contextLen = fmt.Sprint(m.ContextLength)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t\n", name, m.Name, contextLen)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("couldn't list models of %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func listModels(ctx context.Context, config *llm.Config, name string) ([]*llm.Model, error) {
+	name, cfg, err := resolveBackend(config, name)
+	if err != nil {
+		return nil, err
+	}
+	backend, err := newBackend(name, cfg)
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := backend.(ModelLister)
+	if !ok {
+		return nil, fmt.Errorf("backend %q doesn't support listing models", name)
+	}
+	models, err := lister.ListModels(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("backend %q: %w", name, err)
+	}
+	return models, nil
+}
@@ -0,0 +1,171 @@
+package net
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/evmar/ai/llm"
+)
+
+// Interaction is one recorded request/response pair.
+type Interaction struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	RequestBody string      `json:"request_body,omitempty"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        string      `json:"body"`
+}
+
+// Cassette is a file of recorded interactions, with credentials redacted.
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+func LoadCassette(path string) (*Cassette, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Cassette
+	if err := json.Unmarshal(buf, &c); err != nil {
+		return nil, fmt.Errorf("cassette %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+func (c *Cassette) Save(path string) error {
+	buf, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(buf, '\n'), 0644)
+}
+
+func readBody(r *http.Request) (string, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return "", nil
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	return string(body), err
+}
+
+// Recorder sends requests through Inner and appends each exchange to the
+// cassette at Path, rewriting the file as it goes.
+type Recorder struct {
+	// Inner sends the requests; nil means http.DefaultTransport.
+	Inner http.RoundTripper
+	Path  string
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+func (rec *Recorder) RoundTrip(r *http.Request) (*http.Response, error) {
+	return rec.roundTrip(rec.Inner, r)
+}
+
+// Via returns a transport that records into rec's cassette but sends
+// through inner, so clients with different transports can share it.
+func (rec *Recorder) Via(inner http.RoundTripper) http.RoundTripper {
+	return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return rec.roundTrip(inner, r)
+	})
+}
+
+func (rec *Recorder) roundTrip(inner http.RoundTripper, r *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	r = r.Clone(r.Context())
+	r.Body = io.NopCloser(strings.NewReader(reqBody))
+
+	if inner == nil {
+		inner = http.DefaultTransport
+	}
+	resp, err := inner.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	// Recording reads the whole response before returning it, so streamed
+	// responses arrive all at once.
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	header := redactHeader(resp.Header)
+	// These describe the original transfer, not the replayed one.
+	header.Del("Content-Length")
+	header.Del("Date")
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.cassette.Interactions = append(rec.cassette.Interactions, &Interaction{
+		Method:      r.Method,
+		URL:         redactURL(r.URL),
+		RequestBody: reqBody,
+		Status:      resp.StatusCode,
+		Header:      header,
+		Body:        string(body),
+	})
+	if err := rec.cassette.Save(rec.Path); err != nil {
+		return nil, fmt.Errorf("recording: %w", err)
+	}
+	return resp, nil
+}
+
+// Replayer answers requests from a cassette without touching the network.
+// Each interaction is used once, in order among those matching the request's
+// method, URL and body.
+type Replayer struct {
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+}
+
+func NewReplayer(c *Cassette) *Replayer {
+	return &Replayer{cassette: c, used: make([]bool, len(c.Interactions))}
+}
+
+func (rp *Replayer) RoundTrip(r *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	url := redactURL(r.URL)
+
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	for i, in := range rp.cassette.Interactions {
+		if rp.used[i] || in.Method != r.Method || in.URL != url || in.RequestBody != reqBody {
+			continue
+		}
+		rp.used[i] = true
+		header := in.Header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Status, http.StatusText(in.Status)),
+			StatusCode:    in.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(strings.NewReader(in.Body)),
+			ContentLength: int64(len(in.Body)),
+			Request:       r,
+		}, nil
+	}
+	return nil, fmt.Errorf("replay: no recorded response for %s %s with body %s", r.Method, url, llm.Snippet([]byte(reqBody)))
+}
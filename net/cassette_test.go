@@ -0,0 +1,65 @@
+package net
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=secret-cookie")
+		w.Write([]byte("echo " + string(body)))
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	post := func(rt http.RoundTripper, body string) (string, error) {
+		req, _ := http.NewRequest("POST", srv.URL+"/v1/call?key=secret-key", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret-token")
+		resp, err := (&http.Client{Transport: rt}).Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		return string(b), err
+	}
+
+	rec := &Recorder{Path: path}
+	for _, body := range []string{"a", "b"} {
+		if got, err := post(rec, body); err != nil || got != "echo "+body {
+			t.Fatalf("wanted echo %s, got %q, %v", body, got, err)
+		}
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"secret-key", "secret-token", "secret-cookie"} {
+		if strings.Contains(string(buf), secret) {
+			t.Errorf("cassette contains %q:\n%s", secret, buf)
+		}
+	}
+
+	srv.Close()
+	c, err := LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp := NewReplayer(c)
+	// Matched by body, not just order.
+	for _, body := range []string{"b", "a"} {
+		if got, err := post(rp, body); err != nil || got != "echo "+body {
+			t.Fatalf("wanted replayed echo %s, got %q, %v", body, got, err)
+		}
+	}
+	if _, err := post(rp, "a"); err == nil || !strings.Contains(err.Error(), "no recorded response") {
+		t.Fatalf("wanted error replaying a used interaction, got %v", err)
+	}
+}
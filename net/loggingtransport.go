@@ -1,23 +1,234 @@
 package net
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
-	"net/http/httputil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
-type LoggingTransport struct{}
+// Headers and query parameters that carry credentials.
+var (
+	secretHeaders = []string{"Authorization", "Proxy-Authorization", "X-Api-Key", "Api-Key", "X-Goog-Api-Key", "Cookie", "Set-Cookie"}
+	secretParams  = []string{"key", "api_key", "access_token"}
+)
+
+const redacted = "REDACTED"
+
+// LoggingTransport logs each request and response it passes to Inner,
+// with credentials redacted.
+type LoggingTransport struct {
+	// Inner sends the requests; nil means http.DefaultTransport.
+	Inner http.RoundTripper
+	// Out receives the log; nil means os.Stderr.
+	Out io.Writer
+	// MaxBody limits how many bytes of each body are logged; 0 means 4096
+	// and negative means no limit.
+	MaxBody int
+	// JSON writes one JSON object per exchange instead of a text dump.
+	JSON bool
+
+	mu sync.Mutex
+}
+
+// LogEntry is one logged exchange, as written in JSON mode.
+type LogEntry struct {
+	Time            time.Time   `json:"time"`
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	RequestHeaders  http.Header `json:"request_headers,omitempty"`
+	RequestBody     string      `json:"request_body,omitempty"`
+	Status          int         `json:"status,omitempty"`
+	ResponseHeaders http.Header `json:"response_headers,omitempty"`
+	ResponseBody    string      `json:"response_body,omitempty"`
+	Error           string      `json:"error,omitempty"`
+	// HeadersMs is the time until the response headers arrived;
+	// TotalMs includes reading the body.
+	HeadersMs int64 `json:"headers_ms"`
+	TotalMs   int64 `json:"total_ms"`
+}
+
+func redactURL(u *url.URL) string {
+	q := u.Query()
+	changed := false
+	for _, p := range secretParams {
+		if q.Has(p) {
+			q.Set(p, redacted)
+			changed = true
+		}
+	}
+	if !changed {
+		return u.String()
+	}
+	c := *u
+	c.RawQuery = q.Encode()
+	return c.String()
+}
+
+func redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, k := range secretHeaders {
+		if h.Get(k) != "" {
+			h.Set(k, redacted)
+		}
+	}
+	return h
+}
+
+func (s *LoggingTransport) maxBody() int {
+	if s.MaxBody == 0 {
+		return 4096
+	}
+	return s.MaxBody
+}
+
+func (s *LoggingTransport) truncate(body []byte) string {
+	limit := s.maxBody()
+	if limit < 0 || len(body) <= limit {
+		return string(body)
+	}
+	return fmt.Sprintf("%s... (%d bytes total)", body[:limit], len(body))
+}
+
+func (s *LoggingTransport) write(text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := s.Out
+	if out == nil {
+		out = os.Stderr
+	}
+	io.WriteString(out, text)
+}
+
+func writeHeader(b *strings.Builder, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			fmt.Fprintf(b, "%s: %s\n", k, v)
+		}
+	}
+}
 
 func (s *LoggingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
-	bytes, _ := httputil.DumpRequestOut(r, true)
+	return s.roundTrip(s.Inner, r)
+}
+
+// Via returns a transport that logs like s but sends through inner, keeping
+// one log for clients with different transports.
+func (s *LoggingTransport) Via(inner http.RoundTripper) http.RoundTripper {
+	return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return s.roundTrip(inner, r)
+	})
+}
+
+func (s *LoggingTransport) roundTrip(inner http.RoundTripper, r *http.Request) (*http.Response, error) {
+	entry := &LogEntry{
+		Time:           time.Now(),
+		Method:         r.Method,
+		URL:            redactURL(r.URL),
+		RequestHeaders: redactHeader(r.Header),
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		// RoundTrippers mustn't modify the request, so send a copy.
+		r = r.Clone(r.Context())
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		entry.RequestBody = s.truncate(body)
+	}
+	if !s.JSON {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s %s\n", entry.Method, entry.URL)
+		writeHeader(&b, entry.RequestHeaders)
+		fmt.Fprintf(&b, "\n%s\n\n", entry.RequestBody)
+		s.write(b.String())
+	}
 
-	resp, err := http.DefaultTransport.RoundTrip(r)
-	// err is returned after dumping the response
+	if inner == nil {
+		inner = http.DefaultTransport
+	}
+	resp, err := inner.RoundTrip(r)
+	entry.HeadersMs = time.Since(entry.Time).Milliseconds()
+	if err != nil {
+		entry.Error = err.Error()
+		s.finish(entry)
+		return nil, err
+	}
+	entry.Status = resp.StatusCode
+	entry.ResponseHeaders = redactHeader(resp.Header)
+	// Log the body as it's read, so streamed responses still stream.
+	resp.Body = &loggingBody{ReadCloser: resp.Body, t: s, entry: entry}
+	return resp, nil
+}
 
-	respBytes, _ := httputil.DumpResponse(resp, true)
-	bytes = append(bytes, respBytes...)
+// finish logs the completed exchange.
+func (s *LoggingTransport) finish(entry *LogEntry) {
+	entry.TotalMs = time.Since(entry.Time).Milliseconds()
+	if s.JSON {
+		buf, _ := json.Marshal(entry)
+		s.write(string(buf) + "\n")
+		return
+	}
+	var b strings.Builder
+	if entry.Status != 0 {
+		fmt.Fprintf(&b, "%d %s (%dms, %dms total)\n", entry.Status, http.StatusText(entry.Status), entry.HeadersMs, entry.TotalMs)
+		writeHeader(&b, entry.ResponseHeaders)
+		fmt.Fprintf(&b, "\n%s\n\n", entry.ResponseBody)
+	}
+	if entry.Error != "" {
+		fmt.Fprintf(&b, "error: %s (%dms)\n\n", entry.Error, entry.TotalMs)
+	}
+	s.write(b.String())
+}
 
-	fmt.Printf("%s\n", bytes)
+// loggingBody records a response body as it's read and logs the exchange
+// when it's closed.
+type loggingBody struct {
+	io.ReadCloser
+	t     *LoggingTransport
+	entry *LogEntry
+	buf   bytes.Buffer
+	n     int
+	once  sync.Once
+}
+
+func (b *loggingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += n
+	if limit := b.t.maxBody(); limit < 0 {
+		b.buf.Write(p[:n])
+	} else if room := limit - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(n, room)])
+	}
+	if err != nil && err != io.EOF {
+		b.entry.Error = err.Error()
+	}
+	return n, err
+}
 
-	return resp, err
+func (b *loggingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		body := b.buf.String()
+		if b.n > b.buf.Len() {
+			body = fmt.Sprintf("%s... (%d bytes total)", body, b.n)
+		}
+		b.entry.ResponseBody = body
+		b.t.finish(b.entry)
+	})
+	return err
 }
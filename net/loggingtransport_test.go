@@ -0,0 +1,94 @@
+package net
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoggingTransportInner(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	calls := 0
+	inner := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		return http.DefaultTransport.RoundTrip(r)
+	})
+	hc := &http.Client{Transport: &LoggingTransport{Inner: inner, Out: io.Discard}}
+	resp, err := hc.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "ok" || calls != 1 {
+		t.Fatalf("wanted ok via inner transport, got %q after %d calls", body, calls)
+	}
+}
+
+func TestLoggingTransportRedacts(t *testing.T) {
+	var out bytes.Buffer
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sk-secret" {
+			t.Errorf("redaction leaked into the sent request: %q", r.Header.Get("Authorization"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	hc := &http.Client{Transport: &LoggingTransport{Out: &out, MaxBody: 5}}
+	req, _ := http.NewRequest("POST", srv.URL+"/m:generate?key=goog-secret&alt=sse", strings.NewReader("0123456789"))
+	req.Header.Set("Authorization", "Bearer sk-secret")
+	resp, err := hc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "0123456789" {
+		t.Fatalf("wanted body echoed intact, got %q", body)
+	}
+
+	log := out.String()
+	for _, secret := range []string{"sk-secret", "goog-secret"} {
+		if strings.Contains(log, secret) {
+			t.Errorf("log contains %q:\n%s", secret, log)
+		}
+	}
+	for _, want := range []string{"Authorization: REDACTED", "key=REDACTED", "01234... (10 bytes total)", "200 OK"} {
+		if !strings.Contains(log, want) {
+			t.Errorf("wanted log to contain %q:\n%s", want, log)
+		}
+	}
+}
+
+func TestLoggingTransportJSON(t *testing.T) {
+	var out bytes.Buffer
+	failing := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	})
+	hc := &http.Client{Transport: &LoggingTransport{Inner: failing, Out: &out, JSON: true}}
+	if _, err := hc.Get("http://example.invalid/?api_key=secret"); err == nil {
+		t.Fatalf("wanted error")
+	}
+
+	var entry LogEntry
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("wanted one JSON entry, got %q: %v", out.String(), err)
+	}
+	if entry.Error != "connection refused" || entry.Status != 0 {
+		t.Errorf("wanted transport error logged, got %+v", entry)
+	}
+	if entry.URL != "http://example.invalid/?api_key=REDACTED" {
+		t.Errorf("wanted redacted URL, got %q", entry.URL)
+	}
+}
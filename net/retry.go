@@ -0,0 +1,209 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	stdnet "net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy retries requests that fail with rate limits or server errors,
+// using jittered exponential backoff unless the server says how long to wait.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of tries; 0 means 3, 1 disables retries.
+	MaxAttempts int
+	// Timeout bounds the total time spent retrying; 0 means no limit.
+	Timeout time.Duration
+	// BaseDelay and MaxDelay bound the backoff; 0 means 1s and 30s.
+	// A server asking for a longer wait than MaxDelay isn't retried.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Log gets a line for each wait before retrying; nil means stderr.
+	Log io.Writer
+
+	// Retryable classifies an error response, given its already-read body.
+	// If nil, 408, 429 and 5xx statuses are retried.
+	Retryable func(resp *http.Response, body []byte) bool
+	// Delay reads how long an error response asks us to wait, for APIs
+	// that say so in the body rather than in Retry-After.  Like those
+	// headers, it's capped by MaxDelay.
+	Delay func(resp *http.Response, body []byte) (time.Duration, bool)
+
+	sleep func(ctx context.Context, d time.Duration) error // for tests
+}
+
+func defaultRetryable(resp *http.Response, body []byte) bool {
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return resp.StatusCode >= 500
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// serverDelay returns how long the response asks us to wait, if it says.
+func serverDelay(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if ra := resp.Header.Get("Retry-After"); ra != "" {
+		if secs, err := strconv.Atoi(ra); err == nil {
+			return time.Duration(secs) * time.Second, true
+		}
+		if t, err := http.ParseTime(ra); err == nil {
+			return t.Sub(now), true
+		}
+	}
+	// OpenAI-style rate limit headers, e.g. "x-ratelimit-reset-requests: 6m0s".
+	var delay time.Duration
+	found := false
+	for _, kind := range []string{"requests", "tokens"} {
+		if resp.Header.Get("X-Ratelimit-Remaining-"+kind) != "0" {
+			continue
+		}
+		if d, err := time.ParseDuration(resp.Header.Get("X-Ratelimit-Reset-" + kind)); err == nil {
+			found = true
+			delay = max(delay, d)
+		}
+	}
+	return delay, found
+}
+
+func (p *RetryPolicy) maxDelay() time.Duration {
+	if p.MaxDelay == 0 {
+		return 30 * time.Second
+	}
+	return p.MaxDelay
+}
+
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	base, maxDelay := p.BaseDelay, p.maxDelay()
+	if base == 0 {
+		base = time.Second
+	}
+	d := base << attempt
+	if d > maxDelay || d <= 0 {
+		d = maxDelay
+	}
+	// Jitter into [d/2, d) so concurrent clients spread out.
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryableErr reports whether a failed request can be sent again.  A
+// request that isn't idempotent may have taken effect unless the
+// connection was never established.
+func retryableErr(req *http.Request, err error) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	var dnsErr *stdnet.DNSError
+	var opErr *stdnet.OpError
+	return errors.As(err, &dnsErr) || (errors.As(err, &opErr) && opErr.Op == "dial")
+}
+
+// Do sends req with client, retrying as the policy allows.  The request body
+// must be rewindable (as with a bytes.Reader body from http.NewRequest).
+// When retries run out the last response is returned as is.
+func (p *RetryPolicy) Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	attempts := p.MaxAttempts
+	if attempts == 0 {
+		attempts = 3
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = defaultRetryable
+	}
+	doSleep := p.sleep
+	if doSleep == nil {
+		doSleep = sleep
+	}
+	ctx := req.Context()
+	var deadline time.Time
+	if p.Timeout > 0 {
+		deadline = time.Now().Add(p.Timeout)
+	}
+
+	logw := p.Log
+	if logw == nil {
+		logw = os.Stderr
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+
+		resp, err := client.Do(req)
+		// A body we can't rewind can only be sent once.
+		last := attempt+1 >= attempts || (req.Body != nil && req.GetBody == nil)
+		var delay time.Duration
+		var why string
+		if err != nil {
+			if last || ctx.Err() != nil || !retryableErr(req, err) {
+				return nil, err
+			}
+			delay = p.backoff(attempt)
+			if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+				return nil, err
+			}
+			why = err.Error()
+		} else {
+			if resp.StatusCode < 400 || last {
+				return resp, nil
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+			if !retryable(resp, body) {
+				return resp, nil
+			}
+			var ok bool
+			if p.Delay != nil {
+				delay, ok = p.Delay(resp, body)
+			}
+			if !ok {
+				delay, ok = serverDelay(resp, time.Now())
+			}
+			if !ok {
+				delay = p.backoff(attempt)
+			} else if delay > p.maxDelay() {
+				fmt.Fprintf(logw, "%s: %s asks to retry after %s, longer than %s; giving up\n",
+					req.URL.Host, resp.Status, delay.Round(time.Second), p.maxDelay())
+				return resp, nil
+			}
+			if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+				return resp, nil
+			}
+			why = resp.Status
+		}
+
+		fmt.Fprintf(logw, "%s: %s; retrying in %s (attempt %d of %d)\n",
+			req.URL.Host, why, delay.Round(100*time.Millisecond), attempt+2, attempts)
+		if err := doSleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
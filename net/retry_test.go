@@ -0,0 +1,245 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failing returns a server that fails the first n requests with status,
+// and records the bodies it receives.
+func failing(t *testing.T, n int, status int, header http.Header) (*httptest.Server, *[]string) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) <= n {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(status)
+			w.Write([]byte("fail"))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &bodies
+}
+
+// fakeSleep records the delays instead of sleeping.
+func fakeSleep(delays *[]time.Duration) func(context.Context, time.Duration) error {
+	return func(ctx context.Context, d time.Duration) error {
+		*delays = append(*delays, d)
+		return nil
+	}
+}
+
+func post(t *testing.T, p *RetryPolicy, url string) (int, string) {
+	req, err := http.NewRequest("POST", url, strings.NewReader("req"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := p.Do(http.DefaultClient, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestRetry(t *testing.T) {
+	srv, bodies := failing(t, 2, 503, nil)
+	var delays []time.Duration
+	p := &RetryPolicy{BaseDelay: 100 * time.Millisecond, sleep: fakeSleep(&delays)}
+	status, body := post(t, p, srv.URL)
+	if status != 200 || body != "ok" {
+		t.Fatalf("wanted 200 ok, got %d %q", status, body)
+	}
+	if len(*bodies) != 3 || (*bodies)[2] != "req" {
+		t.Fatalf("wanted body resent 3 times, got %q", *bodies)
+	}
+	if len(delays) != 2 {
+		t.Fatalf("wanted 2 delays, got %v", delays)
+	}
+	// Jittered into [d/2, d], doubling each time.
+	if delays[0] < 50*time.Millisecond || delays[0] > 100*time.Millisecond ||
+		delays[1] < 100*time.Millisecond || delays[1] > 200*time.Millisecond {
+		t.Errorf("wanted backoff delays, got %v", delays)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	srv, bodies := failing(t, 5, 500, nil)
+	var delays []time.Duration
+	p := &RetryPolicy{MaxAttempts: 2, sleep: fakeSleep(&delays)}
+	status, body := post(t, p, srv.URL)
+	if status != 500 || body != "fail" {
+		t.Fatalf("wanted last response 500 fail, got %d %q", status, body)
+	}
+	if len(*bodies) != 2 {
+		t.Fatalf("wanted 2 attempts, got %d", len(*bodies))
+	}
+}
+
+func TestRetryNotRetryable(t *testing.T) {
+	srv, bodies := failing(t, 1, 400, nil)
+	p := &RetryPolicy{sleep: fakeSleep(new([]time.Duration))}
+	status, body := post(t, p, srv.URL)
+	if status != 400 || body != "fail" {
+		t.Fatalf("wanted 400 fail, got %d %q", status, body)
+	}
+	if len(*bodies) != 1 {
+		t.Fatalf("wanted 1 attempt, got %d", len(*bodies))
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for _, test := range []struct {
+		header http.Header
+		want   time.Duration
+	}{
+		{http.Header{"Retry-After": {"7"}}, 7 * time.Second},
+		{http.Header{
+			"X-Ratelimit-Remaining-Requests": {"0"},
+			"X-Ratelimit-Reset-Requests":     {"1.5s"},
+			"X-Ratelimit-Remaining-Tokens":   {"0"},
+			"X-Ratelimit-Reset-Tokens":       {"3s"},
+		}, 3 * time.Second},
+	} {
+		srv, _ := failing(t, 1, 429, test.header)
+		var delays []time.Duration
+		p := &RetryPolicy{sleep: fakeSleep(&delays)}
+		if status, _ := post(t, p, srv.URL); status != 200 {
+			t.Fatalf("wanted 200, got %d", status)
+		}
+		if len(delays) != 1 || delays[0] != test.want {
+			t.Errorf("wanted delay %v, got %v", test.want, delays)
+		}
+	}
+}
+
+func TestRetryTimeout(t *testing.T) {
+	srv, bodies := failing(t, 1, 429, http.Header{"Retry-After": {"20"}})
+	p := &RetryPolicy{Timeout: time.Second, sleep: fakeSleep(new([]time.Duration))}
+	if status, _ := post(t, p, srv.URL); status != 429 {
+		t.Fatalf("wanted 429 when delay exceeds timeout, got %d", status)
+	}
+	if len(*bodies) != 1 {
+		t.Fatalf("wanted 1 attempt, got %d", len(*bodies))
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	srv, bodies := failing(t, 1, 429, http.Header{"Retry-After": {"3600"}})
+	var delays []time.Duration
+	var log bytes.Buffer
+	p := &RetryPolicy{Log: &log, sleep: fakeSleep(&delays)}
+	if status, _ := post(t, p, srv.URL); status != 429 {
+		t.Fatalf("wanted 429 when delay exceeds MaxDelay, got %d", status)
+	}
+	if len(*bodies) != 1 || len(delays) != 0 {
+		t.Fatalf("wanted 1 attempt and no sleep, got %d and %v", len(*bodies), delays)
+	}
+	if !strings.Contains(log.String(), "retry after 1h0m0s") {
+		t.Errorf("wanted the delay logged, got %q", log.String())
+	}
+}
+
+func TestRetryLogsWait(t *testing.T) {
+	srv, _ := failing(t, 1, 429, http.Header{"Retry-After": {"2"}})
+	var log bytes.Buffer
+	p := &RetryPolicy{Log: &log, sleep: fakeSleep(new([]time.Duration))}
+	post(t, p, srv.URL)
+	if !strings.Contains(log.String(), "429 Too Many Requests; retrying in 2s (attempt 2 of 3)") {
+		t.Errorf("wanted the wait logged, got %q", log.String())
+	}
+}
+
+func TestRetryPostDropped(t *testing.T) {
+	// The server reads the request then drops the connection, so the
+	// request may have taken effect and mustn't be resent.
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		io.ReadAll(r.Body)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}))
+	t.Cleanup(srv.Close)
+	p := &RetryPolicy{Log: io.Discard, sleep: fakeSleep(new([]time.Duration))}
+	req, err := http.NewRequest("POST", srv.URL, strings.NewReader("req"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Do(http.DefaultClient, req); err == nil {
+		t.Fatalf("wanted error from dropped connection")
+	}
+	if attempts != 1 {
+		t.Fatalf("wanted 1 attempt, got %d", attempts)
+	}
+}
+
+func TestRetryDialError(t *testing.T) {
+	// Nothing listens on a closed server's address.
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	var delays []time.Duration
+	p := &RetryPolicy{Log: io.Discard, sleep: fakeSleep(&delays)}
+	req, err := http.NewRequest("POST", srv.URL, strings.NewReader("req"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Do(http.DefaultClient, req); err == nil {
+		t.Fatalf("wanted dial error")
+	}
+	if len(delays) != 2 {
+		t.Fatalf("wanted POST retried after connection failures, got delays %v", delays)
+	}
+}
+
+func TestRetryBodyDelay(t *testing.T) {
+	// The delay is in the body, as with Gemini's RetryInfo.
+	delayFromBody := func(resp *http.Response, body []byte) (time.Duration, bool) {
+		d, err := time.ParseDuration(string(body))
+		return d, err == nil
+	}
+	for _, test := range []struct {
+		body     string
+		attempts int
+		delays   []time.Duration
+	}{
+		{"7s", 2, []time.Duration{7 * time.Second}},
+		// Over MaxDelay, so given up on like a long Retry-After.
+		{"43s", 1, nil},
+	} {
+		var bodies []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bodies = append(bodies, "")
+			if len(bodies) == 1 {
+				w.WriteHeader(429)
+				w.Write([]byte(test.body))
+				return
+			}
+			w.Write([]byte("ok"))
+		}))
+		defer srv.Close()
+		var delays []time.Duration
+		p := &RetryPolicy{Delay: delayFromBody, Log: io.Discard, sleep: fakeSleep(&delays)}
+		post(t, p, srv.URL)
+		if len(bodies) != test.attempts || !slices.Equal(delays, test.delays) {
+			t.Errorf("%s: wanted %d attempts and delays %v, got %d and %v", test.body, test.attempts, test.delays, len(bodies), delays)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// SSEReader reads the data payloads of a server-sent events stream.
+// See https://html.spec.whatwg.org/multipage/server-sent-events.html.
+type SSEReader struct {
+	scanner *bufio.Scanner
+}
+
+func NewSSEReader(r io.Reader) *SSEReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	return &SSEReader{scanner: scanner}
+}
+
+// Read returns the data of the next event.  It returns io.EOF at the end of
+// input or at OpenAI's "[DONE]" sentinel.
+func (s *SSEReader) Read() ([]byte, error) {
+	var data []byte
+	for s.scanner.Scan() {
+		line := s.scanner.Bytes()
+		if len(line) == 0 {
+			if data == nil {
+				continue
+			}
+			break
+		}
+		field, value, _ := bytes.Cut(line, []byte(":"))
+		value = bytes.TrimPrefix(value, []byte(" "))
+		// Other fields ("event", "id", "retry") and comments (empty field) are ignored.
+		if string(field) == "data" {
+			if data != nil {
+				data = append(data, '\n')
+			}
+			data = append(data, value...)
+		}
+	}
+	if err := s.scanner.Err(); err != nil {
+		return nil, err
+	}
+	if data == nil || string(data) == "[DONE]" {
+		return nil, io.EOF
+	}
+	return data, nil
+}
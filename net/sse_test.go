@@ -0,0 +1,60 @@
+package net
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSSEReader(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []string
+	}{
+		{
+			name: "basic",
+			raw:  "data: a\n\ndata: b\n\n",
+			want: []string{"a", "b"},
+		},
+		{
+			name: "done",
+			raw:  "data: a\n\ndata: [DONE]\n\ndata: b\n\n",
+			want: []string{"a"},
+		},
+		{
+			name: "multiline",
+			raw:  "data: a\ndata: b\n\n",
+			want: []string{"a\nb"},
+		},
+		{
+			name: "comments and fields",
+			raw:  ": keepalive\n\nevent: message\nid: 1\ndata:a\n\n",
+			want: []string{"a"},
+		},
+		{
+			name: "no trailing blank line",
+			raw:  "data: a",
+			want: []string{"a"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewSSEReader(strings.NewReader(test.raw))
+			var got []string
+			for {
+				data, err := r.Read()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, string(data))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("wanted %q, got %q", test.want, got)
+			}
+		})
+	}
+}
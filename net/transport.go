@@ -0,0 +1,20 @@
+package net
+
+import (
+	stdnet "net"
+	"net/http"
+	"time"
+)
+
+// NewTransport returns a transport like http.DefaultTransport that gives up
+// connecting after dialTimeout.  Responses have no overall timeout, since a
+// stream may legitimately run for minutes.
+func NewTransport(dialTimeout time.Duration) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&stdnet.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	return t
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
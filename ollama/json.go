@@ -0,0 +1,50 @@
+package ollama
+
+// Wire types for /api/chat.  These mirror the ollama api package's types,
+// but the version we depend on predates tool support.
+
+import (
+	"encoding/json"
+
+	"github.com/evmar/ai/llm"
+	"github.com/ollama/ollama/api"
+)
+
+type ChatRequest struct {
+	Model    string                 `json:"model"`
+	Messages []Message              `json:"messages"`
+	Tools    []Tool                 `json:"tools,omitempty"`
+	Format   json.RawMessage        `json:"format,omitempty"` // "json" or a schema
+	Options  map[string]interface{} `json:"options,omitempty"`
+	Stream   bool                   `json:"stream"`
+}
+
+type Message struct {
+	Role      string          `json:"role"`
+	Content   string          `json:"content"`
+	Images    []api.ImageData `json:"images,omitempty"`
+	ToolCalls []ToolCall      `json:"tool_calls,omitempty"`
+}
+
+type Tool struct {
+	Type     string    `json:"type"`
+	Function *llm.Tool `json:"function"`
+}
+
+type ToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ChatResponse struct {
+	Message    Message `json:"message"`
+	Done       bool    `json:"done"`
+	DoneReason string  `json:"done_reason"`
+	Error      string  `json:"error"`
+
+	// Token counts, set on the final response.
+	PromptEvalCount int `json:"prompt_eval_count"`
+	EvalCount       int `json:"eval_count"`
+}
@@ -1,8 +1,12 @@
 package ollama
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
@@ -13,8 +17,10 @@ import (
 )
 
 type Client struct {
-	client *api.Client
-	model  string
+	http    *http.Client
+	url     *url.URL
+	model   string
+	options llm.Options
 }
 
 var _ llm.LLM = (*Client)(nil)
@@ -34,7 +40,21 @@ func getClientURL(config *llm.BackendConfig) (*url.URL, error) {
 	}, nil
 }
 
-func New(config *llm.BackendConfig) (*Client, error) {
+type Option func(*Client)
+
+// WithHTTPClient uses hc for API calls, giving up the short dial timeout
+// that makes an unstarted server fail fast.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithTransport wraps the transport with wrap, keeping the short dial
+// timeout underneath it.
+func WithTransport(wrap func(inner http.RoundTripper) http.RoundTripper) Option {
+	return func(c *Client) { c.http = &http.Client{Transport: wrap(c.http.Transport)} }
+}
+
+func New(config *llm.BackendConfig, opts ...Option) (*Client, error) {
 	clientURL, err := getClientURL(config)
 	if err != nil {
 		return nil, err
@@ -44,43 +64,187 @@ func New(config *llm.BackendConfig) (*Client, error) {
 			DialContext: (&net.Dialer{Timeout: 2 * time.Second}).DialContext,
 		},
 	}
-	client := api.NewClient(clientURL, httpClient)
-	return &Client{client: client, model: config.Model}, nil
+	c := &Client{http: httpClient, url: clientURL, model: config.Model, options: config.Options}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Model returns the model calls use, which is the default if the config
+// doesn't name one.
+func (c *Client) Model() string {
+	return c.model
 }
 
-func (c *Client) Call(prompt *llm.Prompt) (string, error) {
-	if prompt.JSON {
-		panic("json not implemented")
+func (c *Client) chatRequest(prompt *llm.Prompt) (*ChatRequest, error) {
+	req := &ChatRequest{Model: c.model, Options: map[string]interface{}{}, Stream: true}
+	if prompt.Schema != nil {
+		req.Format = prompt.Schema
+	} else if prompt.JSON {
+		req.Format = json.RawMessage(`"json"`)
+	}
+	opts := c.options.Merge(prompt.Options)
+	if opts.Temperature != nil {
+		req.Options["temperature"] = *opts.Temperature
+	}
+	if opts.MaxTokens != nil {
+		req.Options["num_predict"] = *opts.MaxTokens
+	}
+	if opts.TopP != nil {
+		req.Options["top_p"] = *opts.TopP
+	}
+	if opts.Stop != nil {
+		req.Options["stop"] = opts.Stop
+	}
+	if opts.Seed != nil {
+		req.Options["seed"] = *opts.Seed
+	}
+	for _, tool := range prompt.Tools {
+		req.Tools = append(req.Tools, Tool{Type: "function", Function: tool})
 	}
-	ctx := context.Background()
-	if len(prompt.Messages) == 1 {
-		if prompt.System != "" {
-			panic("system prompt not implemented")
+	if prompt.System != "" {
+		req.Messages = append(req.Messages, Message{
+			Role:    "system",
+			Content: prompt.System,
+		})
+	}
+	for _, msg := range prompt.Messages {
+		if msg.Role == llm.RoleTool {
+			// Each result is its own message.
+			for _, part := range msg.Parts {
+				r, ok := part.(*llm.ToolResult)
+				if !ok {
+					return nil, fmt.Errorf("ollama: unexpected %T in tool message", part)
+				}
+				req.Messages = append(req.Messages, Message{Role: "tool", Content: r.Content})
+			}
+			continue
 		}
 
-		req := &api.ChatRequest{Model: c.model}
-		for i, prompt := range prompt.Messages {
-			var role string
-			if i%2 == 0 {
-				role = "user"
-			} else {
-				role = "assistant"
+		m := Message{Role: string(msg.Role)}
+		for _, part := range msg.Parts {
+			switch part := part.(type) {
+			case *llm.Text:
+				m.Content += part.Text
+			case *llm.Image:
+				m.Images = append(m.Images, api.ImageData(part.Data))
+			case *llm.ToolCall:
+				tc := ToolCall{}
+				tc.Function.Name = part.Name
+				tc.Function.Arguments = part.Arguments
+				m.ToolCalls = append(m.ToolCalls, tc)
+			default:
+				return nil, fmt.Errorf("ollama: %T parts not supported", part)
 			}
-			req.Messages = append(req.Messages, api.Message{
-				Role:    role,
-				Content: prompt,
-			})
 		}
-		resp := func(resp api.ChatResponse) error {
-			fmt.Print(resp.Message.Content)
-			return nil
+		req.Messages = append(req.Messages, m)
+	}
+	return req, nil
+}
+
+// Stream reads the newline-delimited JSON responses from /api/chat.
+type Stream struct {
+	scanner *bufio.Scanner
+	body    io.Closer
+	pending []llm.Event
+}
+
+func events(resp *ChatResponse) []llm.Event {
+	var evs []llm.Event
+	if resp.Message.Content != "" {
+		evs = append(evs, &llm.TextDelta{Text: resp.Message.Content})
+	}
+	for _, tc := range resp.Message.ToolCalls {
+		evs = append(evs, &llm.ToolCall{Name: tc.Function.Name, Arguments: tc.Function.Arguments})
+	}
+	if resp.Done {
+		reason := resp.DoneReason
+		if reason == "" {
+			reason = "stop"
+		}
+		evs = append(evs, &llm.Finish{Reason: reason})
+		evs = append(evs, &llm.Usage{InputTokens: resp.PromptEvalCount, OutputTokens: resp.EvalCount})
+	}
+	return evs
+}
+
+func (s *Stream) Next() (llm.Event, error) {
+	for len(s.pending) == 0 {
+		if !s.scanner.Scan() {
+			if err := s.scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
 		}
-		err := c.client.Chat(ctx, req, resp)
+		var resp ChatResponse
+		if err := json.Unmarshal(s.scanner.Bytes(), &resp); err != nil {
+			return nil, fmt.Errorf("ollama: parsing response: %w", err)
+		}
+		if resp.Error != "" {
+			return nil, &llm.APIError{Provider: "ollama", Message: resp.Error}
+		}
+		s.pending = events(&resp)
+	}
+	ev := s.pending[0]
+	s.pending = s.pending[1:]
+	return ev, nil
+}
+
+func (s *Stream) Close() error {
+	return s.body.Close()
+}
+
+// ListModels lists the locally available models.  Ollama's list doesn't
+// give context lengths.
+func (c *Client) ListModels(ctx context.Context) ([]*llm.Model, error) {
+	list, err := api.NewClient(c.url, c.http).List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var models []*llm.Model
+	for _, m := range list.Models {
+		models = append(models, &llm.Model{Name: m.Name})
+	}
+	return models, nil
+}
+
+func (c *Client) Call(ctx context.Context, prompt *llm.Prompt) (llm.Stream, error) {
+	chatReq, err := c.chatRequest(prompt)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(chatReq)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url.JoinPath("/api/chat").String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/x-ndjson")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
 		if err != nil {
-			return "", err
+			return nil, err
+		}
+		var errResp ChatResponse
+		if json.Unmarshal(body, &errResp) != nil {
+			// E.g. a proxy's error page.  If it's empty too, APIError
+			// reports the status instead.
+			errResp.Error = llm.Snippet(body)
 		}
+		return nil, &llm.APIError{Provider: "ollama", Status: resp.StatusCode, Message: errResp.Error}
 	}
 
-	// TODO: streaming, not printing
-	return "", nil
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	return &Stream{scanner: scanner, body: resp.Body}, nil
 }
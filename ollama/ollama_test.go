@@ -0,0 +1,240 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/evmar/ai/llm"
+)
+
+// fakeChat serves /api/chat, recording the request and replying with the
+// given chunks as newline-delimited JSON.
+func fakeChat(t *testing.T, req *ChatRequest, chunks ...string) *Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			http.NotFound(w, r)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for i, chunk := range chunks {
+			done := i == len(chunks)-1
+			fmt.Fprintf(w, `{"model":"test","message":{"role":"assistant","content":%q},"done":%v}`+"\n", chunk, done)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	c, err := New(&llm.BackendConfig{URL: server.URL, Model: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCall(t *testing.T) {
+	var req ChatRequest
+	c := fakeChat(t, &req, "Hello", ", world")
+
+	stream, err := c.Call(context.Background(), &llm.Prompt{Messages: llm.Alternating("hi")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := llm.Collect(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "Hello, world" {
+		t.Fatalf("wanted %q, got %q", "Hello, world", text)
+	}
+	if req.Model != "test" {
+		t.Errorf("wanted model test, got %q", req.Model)
+	}
+}
+
+func TestCallPrompt(t *testing.T) {
+	var req ChatRequest
+	c := fakeChat(t, &req, "{}")
+
+	prompt := &llm.Prompt{
+		System:   "be terse",
+		JSON:     true,
+		Messages: llm.Alternating("q1", "a1", "q2"),
+	}
+	prompt.Messages[0].Parts = append(prompt.Messages[0].Parts, &llm.Image{MimeType: "image/png", Data: []byte("png")})
+	stream, err := c.Call(context.Background(), prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := llm.Collect(stream); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(req.Format) != `"json"` {
+		t.Errorf("wanted format json, got %s", req.Format)
+	}
+	roles := []string{"system", "user", "assistant", "user"}
+	contents := []string{"be terse", "q1", "a1", "q2"}
+	if len(req.Messages) != len(roles) {
+		t.Fatalf("wanted %d messages, got %d", len(roles), len(req.Messages))
+	}
+	for i, msg := range req.Messages {
+		if msg.Role != roles[i] || msg.Content != contents[i] {
+			t.Errorf("message %d: wanted %s %q, got %s %q", i, roles[i], contents[i], msg.Role, msg.Content)
+		}
+	}
+	if imgs := req.Messages[1].Images; len(imgs) != 1 || string(imgs[0]) != "png" {
+		t.Errorf("wanted image on first user message, got %v", imgs)
+	}
+}
+
+func TestCallError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, `{"error":"model 'test' not found, try pulling it first"}`)
+	}))
+	defer server.Close()
+
+	c, err := New(&llm.BackendConfig{URL: server.URL, Model: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Call(context.Background(), &llm.Prompt{Messages: llm.Alternating("hi")})
+	exp := "ollama: model 'test' not found, try pulling it first"
+	if err == nil || err.Error() != exp {
+		t.Fatalf("wanted %q, got %v", exp, err)
+	}
+	var apiErr *llm.APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != 404 || apiErr.Retryable() {
+		t.Fatalf("wanted non-retryable 404 APIError, got %#v", err)
+	}
+}
+
+func TestToolCall(t *testing.T) {
+	var req ChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"decl","arguments":{"name":"main"}}}]},"done":false}`)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop"}`)
+	}))
+	defer server.Close()
+
+	c, err := New(&llm.BackendConfig{URL: server.URL, Model: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool := &llm.Tool{Name: "decl", Parameters: json.RawMessage(`{"type":"object"}`)}
+	stream, err := c.Call(context.Background(), &llm.Prompt{Messages: llm.Alternating("hi"), Tools: []*llm.Tool{tool}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	ev, err := stream.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	call, ok := ev.(*llm.ToolCall)
+	if !ok || call.Name != "decl" || string(call.Arguments) != `{"name":"main"}` {
+		t.Fatalf("wanted decl tool call, got %#v", ev)
+	}
+	if len(req.Tools) != 1 || req.Tools[0].Type != "function" || req.Tools[0].Function.Name != "decl" {
+		t.Fatalf("wanted decl tool in request, got %#v", req.Tools)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	sent := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"model":"test","message":{"role":"assistant","content":"partial"},"done":false}`)
+		w.(http.Flusher).Flush()
+		close(sent)
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+	c, err := New(&llm.BackendConfig{URL: server.URL, Model: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := c.Call(ctx, &llm.Prompt{Messages: llm.Alternating("hi")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-sent
+	go cancel()
+	resp, err := llm.ReadResponse(stream)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("wanted context.Canceled, got %v", err)
+	}
+	if resp.Text != "partial" {
+		t.Fatalf("wanted partial text kept, got %q", resp.Text)
+	}
+}
+
+func TestListModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"models":[{"name":"llama3.2:1b","model":"llama3.2:1b","size":1321098329}]}`))
+	}))
+	defer server.Close()
+
+	c, err := New(&llm.BackendConfig{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	models, err := c.ListModels(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 1 || models[0].Name != "llama3.2:1b" {
+		t.Fatalf("wanted llama3.2:1b, got %+v", models)
+	}
+}
+
+func TestWithTransport(t *testing.T) {
+	var inner http.RoundTripper
+	c, err := New(&llm.BackendConfig{URL: "http://localhost:11434"}, WithTransport(func(rt http.RoundTripper) http.RoundTripper {
+		inner = rt
+		return rt
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The wrapper must sit over the dial-timeout transport, not replace it.
+	if tr, ok := inner.(*http.Transport); !ok || tr.DialContext == nil {
+		t.Fatalf("wanted the client's own transport wrapped, got %#v", inner)
+	}
+	if c.http.Transport != inner {
+		t.Fatalf("wanted the wrapped transport used")
+	}
+}
+
+func TestCallErrorNotJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprintln(w, "<html>bad gateway</html>")
+	}))
+	defer server.Close()
+
+	c, err := New(&llm.BackendConfig{URL: server.URL, Model: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Call(context.Background(), &llm.Prompt{Messages: llm.Alternating("hi")})
+	exp := "ollama: <html>bad gateway</html>"
+	if err == nil || err.Error() != exp {
+		t.Fatalf("wanted %q, got %v", exp, err)
+	}
+}
@@ -0,0 +1,41 @@
+package ollama
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/evmar/ai/llm"
+	"github.com/evmar/ai/net"
+)
+
+func TestReplay(t *testing.T) {
+	cassette, err := net.LoadCassette("testdata/chat.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(&llm.BackendConfig{URL: "http://localhost:11434", Model: "llama3.2:1b"},
+		WithHTTPClient(&http.Client{Transport: net.NewReplayer(cassette)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	temp := 0.0
+	stream, err := c.Call(context.Background(), &llm.Prompt{
+		System:   "Be brief.",
+		Messages: llm.Alternating("Say hello."),
+		Options:  llm.Options{Temperature: &temp},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := llm.ReadResponse(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "Hello there!" || resp.FinishReason != "stop" {
+		t.Fatalf("wanted Hello there!/stop, got %q/%q", resp.Text, resp.FinishReason)
+	}
+	if resp.Usage == nil || resp.Usage.InputTokens != 26 || resp.Usage.OutputTokens != 4 {
+		t.Errorf("wanted usage 26/4, got %+v", resp.Usage)
+	}
+}
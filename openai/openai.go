@@ -2,6 +2,7 @@ package openai
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -9,62 +10,155 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/evmar/ai/llm"
 	"github.com/evmar/ai/net"
 	"github.com/evmar/ai/rawjson"
 )
 
-type Error struct {
-	Message string
-}
-
-func (e *Error) Error() string {
-	return fmt.Sprintf("openai: %s", e.Message)
-}
-
-func getError(j *rawjson.RJSON) *Error {
+func getError(j *rawjson.RJSON) *llm.APIError {
 	j = j.Get("error")
 	if j == nil {
 		return nil
 	}
-	return &Error{
-		Message: j.Get("message").String(),
+	if j.IsString() {
+		// Some compatible servers send {"error": "message"}.
+		return &llm.APIError{Provider: "openai", Message: j.String()}
+	}
+	return &llm.APIError{
+		Provider: "openai",
+		Type:     j.OptString("type"),
+		Code:     j.OptString("code"),
+		Message:  j.OptString("message"),
+	}
+}
+
+// httpError converts an HTTP error response to an APIError.
+func httpError(resp *http.Response, body []byte) *llm.APIError {
+	var e *llm.APIError
+	if j, err := rawjson.Parse(body); err == nil {
+		e = getError(j)
+	}
+	if e == nil {
+		// E.g. an HTML page from a proxy.
+		e = &llm.APIError{Provider: "openai", Message: llm.Snippet(body)}
 	}
+	e.Status = resp.StatusCode
+	e.RequestID = resp.Header.Get("X-Request-Id")
+	return e
 }
 
+const (
+	defaultURL   = "https://api.openai.com/v1"
+	defaultModel = "gpt-4o-mini"
+)
+
+// defaultClient is shared by clients so they reuse connections.
+var defaultClient = &http.Client{Transport: net.NewTransport(10 * time.Second)}
+
 type Client struct {
 	token   string
-	Verbose bool
+	url     string
+	model   string
+	options llm.Options
+	retry   *net.RetryPolicy
+	http    *http.Client
+	// api is "chat" or "responses"; see llm.BackendConfig.API.
+	api string
+}
+
+type Option func(*Client)
+
+// WithHTTPClient replaces the client's HTTP client, e.g. with one that
+// replays a cassette in tests.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithTransport wraps the transport requests go through, keeping its
+// connection timeouts, e.g. to log or record them.
+func WithTransport(wrap func(inner http.RoundTripper) http.RoundTripper) Option {
+	return func(c *Client) { c.http = &http.Client{Transport: wrap(c.http.Transport)} }
 }
 
 var _ llm.LLM = (*Client)(nil)
 
-func New() (*Client, error) {
-	openaiToken := os.Getenv("OPENAI_API_KEY")
-	if openaiToken == "" {
-		return nil, fmt.Errorf("set OPENAI_API_KEY")
+// New creates a client for the OpenAI API, or for any OpenAI-compatible
+// server if config.URL is set.  The API key is only required for the real
+// OpenAI endpoint.
+func New(config *llm.BackendConfig, opts ...Option) (*Client, error) {
+	token, err := config.APIKey("OPENAI_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		token:   token,
+		url:     strings.TrimSuffix(config.URL, "/"),
+		model:   config.Model,
+		options: config.Options,
+		http:    defaultClient,
+		api:     config.API,
+	}
+	switch c.api {
+	case "":
+		c.api = "chat"
+	case "chat", "responses":
+	default:
+		return nil, fmt.Errorf("openai: invalid api %q, must be one of {chat,responses}", c.api)
 	}
-	return &Client{token: openaiToken}, nil
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.retry = &net.RetryPolicy{
+		MaxAttempts: config.MaxAttempts,
+		Timeout:     config.RetryTimeout,
+		Retryable: func(resp *http.Response, body []byte) bool {
+			return httpError(resp, body).Retryable()
+		},
+	}
+	if c.url == "" {
+		c.url = defaultURL
+		if c.token == "" {
+			return nil, fmt.Errorf("set OPENAI_API_KEY or api_key_env, api_key_file, api_key_cmd")
+		}
+	}
+	if c.model == "" {
+		c.model = defaultModel
+	}
+	return c, nil
+}
+
+// Model returns the model calls use, which is the default if the config
+// doesn't name one.
+func (oai *Client) Model() string {
+	return oai.model
 }
 
-func (oai *Client) call(url string, jsonReq map[string]interface{}) ([]byte, error) {
+// post POSTs jsonReq to path (e.g. "/chat/completions") under the base URL.
+func (oai *Client) post(ctx context.Context, path string, jsonReq map[string]interface{}) (*http.Response, error) {
 	body, err := json.Marshal(jsonReq)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", oai.url+path, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", "Bearer "+oai.token)
+	return oai.do(req)
+}
 
-	if oai.Verbose {
-		http.DefaultClient.Transport = &net.LoggingTransport{}
+// do sends req with the API key, converting error responses to APIErrors.
+func (oai *Client) do(req *http.Request) (*http.Response, error) {
+	if oai.token != "" {
+		req.Header.Add("Authorization", "Bearer "+oai.token)
 	}
-	resp, err := http.DefaultClient.Do(req)
+
+	resp, err := oai.retry.Do(oai.http, req)
 	if err != nil {
 		return nil, err
 	}
@@ -73,31 +167,191 @@ func (oai *Client) call(url string, jsonReq map[string]interface{}) ([]byte, err
 	if processing != "" {
 		log.Printf("processing time: %s", processing)
 	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return nil, httpError(resp, body)
+	}
+	return resp, nil
+}
 
-	body, err = io.ReadAll(resp.Body)
+// call is like post but reads the whole response body.
+func (oai *Client) call(ctx context.Context, path string, jsonReq map[string]interface{}) ([]byte, error) {
+	resp, err := oai.post(ctx, path, jsonReq)
 	if err != nil {
 		return nil, err
 	}
-	// snip := body
-	// if len(snip) > 1000 {
-	// 	snip = snip[:1000]
-	// }
-	return body, err
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// ListModels lists the models from the /models endpoint.  OpenAI doesn't
+// give their context lengths, but some compatible servers do.
+func (oai *Client) ListModels(ctx context.Context) ([]*llm.Model, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", oai.url+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := oai.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+			// OpenRouter
+			ContextLength int `json:"context_length"`
+			// vLLM
+			MaxModelLen int `json:"max_model_len"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("parsing models: %w", err)
+	}
+	var models []*llm.Model
+	for _, m := range list.Data {
+		models = append(models, &llm.Model{Name: m.ID, ContextLength: max(m.ContextLength, m.MaxModelLen)})
+	}
+	slices.SortFunc(models, func(a, b *llm.Model) int { return strings.Compare(a.Name, b.Name) })
+	return models, nil
+}
+
+// strictSchema reports whether schema meets the rules of OpenAI's strict
+// mode, which guarantees conforming output but rejects other schemas: every
+// object must list all its properties as required and disallow others.
+// See https://platform.openai.com/docs/guides/structured-outputs.
+// Other schemas are sent non-strict, relying on validation afterwards.
+func strictSchema(schema json.RawMessage) bool {
+	var s interface{}
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return false
+	}
+	var strict func(v interface{}) bool
+	strict = func(v interface{}) bool {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if props, ok := v["properties"].(map[string]interface{}); ok || v["type"] == "object" {
+				if v["additionalProperties"] != false {
+					return false
+				}
+				required, _ := v["required"].([]interface{})
+				if len(required) != len(props) {
+					return false
+				}
+			}
+			for _, sub := range v {
+				if !strict(sub) {
+					return false
+				}
+			}
+		case []interface{}:
+			for _, sub := range v {
+				if !strict(sub) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return strict(s)
 }
 
-func parse(body []byte) (string, error) {
+// completion converts a whole chat.completion, from a server that didn't
+// stream, into the events streaming it would have produced.
+func completion(contentType string, body []byte) (llm.Stream, error) {
 	j, err := rawjson.Parse(body)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("openai: wanted an event stream, got %q: %s", contentType, llm.Snippet(body))
 	}
 	if err := getError(j); err != nil {
-		return "", err
+		return nil, err
+	}
+	var c chunk
+	if err := json.Unmarshal(body, &c); err != nil {
+		return nil, fmt.Errorf("openai: parsing completion: %w", err)
+	}
+	for i := range c.Choices {
+		choice := &c.Choices[i]
+		if choice.Message != nil {
+			choice.Delta = *choice.Message
+			// The calls are whole, so unlike deltas they have no index.
+			for j := range choice.Delta.ToolCalls {
+				choice.Delta.ToolCalls[j].Index = j
+			}
+		}
 	}
+	s := &Stream{}
+	s.handle(&c)
+	s.flushToolCalls()
+	return llm.StaticStream(s.pending...), nil
+}
+
+func dataURL(mimeType string, data []byte) string {
+	return fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(data))
+}
+
+// audioFormat maps a MIME type to the input_audio format names.
+func audioFormat(mimeType string) (string, error) {
+	switch mimeType {
+	case "audio/wav", "audio/x-wav", "audio/wave":
+		return "wav", nil
+	case "audio/mpeg", "audio/mp3":
+		return "mp3", nil
+	}
+	return "", fmt.Errorf("openai: unsupported audio type %s", mimeType)
+}
 
-	return j.Get("choices").GetIndex(0).Get("message").Get("content").String(), nil
+// content converts user message parts to a content array.
+func content(parts []llm.Part) ([]interface{}, error) {
+	content := []interface{}{}
+	for _, part := range parts {
+		switch part := part.(type) {
+		case *llm.Text:
+			content = append(content, map[string]interface{}{
+				"type": "text",
+				"text": part.Text,
+			})
+		case *llm.Image:
+			content = append(content, map[string]interface{}{
+				"type": "image_url",
+				"image_url": map[string]interface{}{
+					"url":    dataURL(part.MimeType, part.Data),
+					"detail": "high",
+				},
+			})
+		case *llm.Audio:
+			format, err := audioFormat(part.MimeType)
+			if err != nil {
+				return nil, err
+			}
+			content = append(content, map[string]interface{}{
+				"type": "input_audio",
+				"input_audio": map[string]interface{}{
+					"data":   part.Data, // []byte marshals as base64
+					"format": format,
+				},
+			})
+		case *llm.File:
+			content = append(content, map[string]interface{}{
+				"type": "file",
+				"file": map[string]interface{}{
+					"filename":  part.Name,
+					"file_data": dataURL(part.MimeType, part.Data),
+				},
+			})
+		default:
+			return nil, fmt.Errorf("openai: unexpected %T in user message", part)
+		}
+	}
+	return content, nil
 }
 
-func (oai *Client) Call(prompt *llm.Prompt) (string, error) {
+// messages converts the prompt to the chat completions messages array.
+func messages(prompt *llm.Prompt) ([]interface{}, error) {
 	messages := []interface{}{}
 	if prompt.System != "" {
 		messages = append(messages,
@@ -108,57 +362,144 @@ func (oai *Client) Call(prompt *llm.Prompt) (string, error) {
 		)
 	}
 
-	imageContent := []interface{}{}
-	for _, img := range prompt.Images {
-		imageContent = append(imageContent, map[string]interface{}{
-			"type": "image_url",
-			"image_url": map[string]interface{}{
-				"url":    fmt.Sprintf("data:%s;base64,%s", img.MimeType, base64.StdEncoding.EncodeToString(img.Data)),
-				"detail": "high",
-			},
-		})
-	}
+	for _, msg := range prompt.Messages {
+		switch msg.Role {
+		case llm.RoleUser:
+			var c interface{}
+			if len(msg.Parts) == 1 {
+				if t, ok := msg.Parts[0].(*llm.Text); ok {
+					c = t.Text
+				}
+			}
+			if c == nil {
+				var err error
+				if c, err = content(msg.Parts); err != nil {
+					return nil, err
+				}
+			}
+			messages = append(messages, map[string]interface{}{
+				"role":    "user",
+				"content": c,
+			})
 
-	for i, prompt := range prompt.Messages {
-		var role string
-		if i%2 == 0 {
-			role = "user"
-		} else {
-			role = "assistant"
-		}
+		case llm.RoleAssistant:
+			m := map[string]interface{}{
+				"role":    "assistant",
+				"content": msg.Text(),
+			}
+			if calls := msg.ToolCalls(); len(calls) > 0 {
+				toolCalls := []interface{}{}
+				for _, call := range calls {
+					toolCalls = append(toolCalls, map[string]interface{}{
+						"id":   call.ID,
+						"type": "function",
+						"function": map[string]interface{}{
+							"name":      call.Name,
+							"arguments": string(call.Arguments),
+						},
+					})
+				}
+				m["tool_calls"] = toolCalls
+			}
+			messages = append(messages, m)
 
-		var content interface{} = prompt
-		if i == 0 && len(imageContent) > 0 {
-			imageContent = append(imageContent, map[string]interface{}{
-				"type": "text",
-				"text": prompt,
-			})
-			content = imageContent
+		case llm.RoleTool:
+			// Each result is its own message.
+			for _, part := range msg.Parts {
+				r, ok := part.(*llm.ToolResult)
+				if !ok {
+					return nil, fmt.Errorf("openai: unexpected %T in tool message", part)
+				}
+				messages = append(messages, map[string]interface{}{
+					"role":         "tool",
+					"tool_call_id": r.ID,
+					"content":      r.Content,
+				})
+			}
+
+		default:
+			return nil, fmt.Errorf("openai: unknown role %q", msg.Role)
 		}
-		messages = append(messages, map[string]interface{}{
-			"role":    role,
-			"content": content,
-		})
+	}
+	return messages, nil
+}
+
+func (oai *Client) Call(ctx context.Context, prompt *llm.Prompt) (llm.Stream, error) {
+	if oai.api == "responses" {
+		return oai.callResponses(ctx, prompt)
+	}
+	messages, err := messages(prompt)
+	if err != nil {
+		return nil, err
 	}
 
 	params := map[string]interface{}{
-		"model":      "gpt-4o-mini",
-		"messages":   messages,
-		"max_tokens": 500,
+		"model":    oai.model,
+		"messages": messages,
+	}
+	opts := oai.options.Merge(prompt.Options)
+	if opts.Temperature != nil {
+		params["temperature"] = *opts.Temperature
+	}
+	if opts.MaxTokens != nil {
+		params["max_tokens"] = *opts.MaxTokens
+	}
+	if opts.TopP != nil {
+		params["top_p"] = *opts.TopP
+	}
+	if opts.Stop != nil {
+		params["stop"] = opts.Stop
 	}
-	if prompt.JSON {
+	if opts.Seed != nil {
+		params["seed"] = *opts.Seed
+	}
+	if opts.ReasoningEffort != "" {
+		params["reasoning_effort"] = opts.ReasoningEffort
+	}
+	if len(prompt.Tools) > 0 {
+		tools := []interface{}{}
+		for _, tool := range prompt.Tools {
+			tools = append(tools, map[string]interface{}{
+				"type":     "function",
+				"function": tool,
+			})
+		}
+		params["tools"] = tools
+	}
+	if prompt.Schema != nil {
+		params["response_format"] = map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   "response",
+				"schema": prompt.Schema,
+				"strict": strictSchema(prompt.Schema),
+			},
+		}
+	} else if prompt.JSON {
 		params["response_format"] = map[string]interface{}{"type": "json_object"}
 	}
 
-	body, err := oai.call("https://api.openai.com/v1/chat/completions", params)
+	params["stream"] = true
+	params["stream_options"] = map[string]interface{}{"include_usage": true}
+
+	resp, err := oai.post(ctx, "/chat/completions", params)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		// An error, or a server that doesn't support streaming.
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return completion(ct, body)
 	}
-	return parse(body)
+	return &Stream{r: net.NewSSEReader(resp.Body), body: resp.Body}, nil
 }
 
-func (oai *Client) CallSpeech(text, outPath string) error {
-	body, err := oai.call("https://api.openai.com/v1/audio/speech", map[string]interface{}{
+func (oai *Client) CallSpeech(ctx context.Context, text, outPath string) error {
+	body, err := oai.call(ctx, "/audio/speech", map[string]interface{}{
 		"model": "tts-1",
 		"input": text,
 		"voice": "alloy",
@@ -1,10 +1,17 @@
 package openai
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/evmar/ai/llm"
 	"github.com/evmar/ai/rawjson"
 )
 
@@ -44,12 +51,50 @@ func TestOk(t *testing.T) {
   "service_tier": "default",
   "system_fingerprint": null
 }`
-	msg, err := parse([]byte(responseText))
+	stream, err := completion("application/json", []byte(responseText))
 	if err != nil {
 		t.Fatal(err)
 	}
-	if msg != "Hello! How can I assist you today?" {
-		t.Fatalf("wanted Hello! How can I assist you today?, got %q", msg)
+	resp, err := llm.ReadResponse(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "Hello! How can I assist you today?" {
+		t.Fatalf("wanted Hello! How can I assist you today?, got %q", resp.Text)
+	}
+	if resp.FinishReason != "stop" {
+		t.Errorf("wanted finish stop, got %q", resp.FinishReason)
+	}
+	if resp.Usage == nil || resp.Usage.InputTokens != 13 || resp.Usage.OutputTokens != 10 {
+		t.Errorf("wanted usage 13/10, got %+v", resp.Usage)
+	}
+}
+
+func TestCompletionToolCalls(t *testing.T) {
+	body := `{"object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[` +
+		`{"id":"c1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Paris\"}"}},` +
+		`{"id":"c2","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Rome\"}"}}]},` +
+		`"finish_reason":"tool_calls"}]}`
+	stream, err := completion("application/json", []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := llm.ReadResponse(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.ToolCalls) != 2 || resp.ToolCalls[0].ID != "c1" || string(resp.ToolCalls[1].Arguments) != `{"city":"Rome"}` {
+		t.Fatalf("wanted 2 weather calls, got %+v", resp.ToolCalls)
+	}
+	if resp.FinishReason != "tool_calls" {
+		t.Errorf("wanted finish tool_calls, got %q", resp.FinishReason)
+	}
+
+	if _, err := completion("text/html", []byte("<html>")); err == nil || !strings.Contains(err.Error(), "text/html") {
+		t.Fatalf("wanted error for html, got %v", err)
+	}
+	if _, err := completion("application/json", []byte(`{"error":"model not loaded"}`)); err == nil || !strings.Contains(err.Error(), "model not loaded") {
+		t.Fatalf("wanted server error, got %v", err)
 	}
 }
 
@@ -78,5 +123,228 @@ func TestQuotaError(t *testing.T) {
 			t.Fatalf("wanted prefix %q, got %q", exp, err.Error())
 		}
 		log.Println(err)
+		if err.Type != "insufficient_quota" || err.Retryable() {
+			t.Fatalf("wanted non-retryable insufficient_quota, got %+v", err)
+		}
+	}
+}
+
+func TestHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-Id", "req_123")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{
+    "error": {
+        "message": "Rate limit reached for gpt-4o-mini in organization org-xxx on requests per min (RPM): Limit 3, Used 3, Requested 1.",
+        "type": "requests",
+        "param": null,
+        "code": "rate_limit_exceeded"
+    }
+}`))
+	}))
+	defer server.Close()
+
+	c, err := New(&llm.BackendConfig{URL: server.URL, MaxAttempts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Call(context.Background(), &llm.Prompt{Messages: llm.Alternating("hello")})
+	var apiErr *llm.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("wanted APIError, got %v", err)
+	}
+	if apiErr.Status != 429 || apiErr.Code != "rate_limit_exceeded" || apiErr.RequestID != "req_123" {
+		t.Fatalf("wrong error fields: %+v", apiErr)
+	}
+	if !apiErr.Retryable() {
+		t.Fatalf("wanted rate limit to be retryable")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= 2 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error": {"message": "overloaded", "type": "server_error"}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\ndata: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	c, err := New(&llm.BackendConfig{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := c.Call(context.Background(), &llm.Prompt{Messages: llm.Alternating("hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := llm.Collect(stream); err != nil || msg != "hi" {
+		t.Fatalf("wanted hi, got %q, %v", msg, err)
+	}
+	if calls != 3 {
+		t.Fatalf("wanted 3 calls, got %d", calls)
+	}
+}
+
+func TestCompatibleServer(t *testing.T) {
+	var req map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			t.Errorf("wanted no auth header, got %q", auth)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\ndata: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	t.Setenv("OPENAI_API_KEY", "")
+	c, err := New(&llm.BackendConfig{URL: server.URL + "/v1/", Model: "local-model"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := c.Call(context.Background(), &llm.Prompt{Messages: llm.Alternating("hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := llm.Collect(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != "hi" {
+		t.Fatalf("wanted hi, got %q", msg)
+	}
+	if req["model"] != "local-model" {
+		t.Fatalf("wanted model local-model, got %v", req["model"])
+	}
+	if req["stream"] != true {
+		t.Fatalf("wanted stream request, got %v", req["stream"])
+	}
+}
+
+func TestMessages(t *testing.T) {
+	prompt := &llm.Prompt{
+		System: "sys",
+		Messages: []*llm.Message{
+			{Role: llm.RoleUser, Parts: []llm.Part{
+				&llm.Image{MimeType: "image/png", Data: []byte("png")},
+				&llm.Audio{MimeType: "audio/wav", Data: []byte("wav")},
+				&llm.Text{Text: "what's this?"},
+			}},
+			{Role: llm.RoleAssistant, Parts: []llm.Part{
+				&llm.ToolCall{ID: "c1", Name: "look", Arguments: json.RawMessage(`{}`)},
+			}},
+			{Role: llm.RoleTool, Parts: []llm.Part{
+				&llm.ToolResult{ID: "c1", Name: "look", Content: "a cat"},
+			}},
+		},
+	}
+	msgs, err := messages(prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `[{"content":"sys","role":"system"},` +
+		`{"content":[{"image_url":{"detail":"high","url":"data:image/png;base64,cG5n"},"type":"image_url"},` +
+		`{"input_audio":{"data":"d2F2","format":"wav"},"type":"input_audio"},` +
+		`{"text":"what's this?","type":"text"}],"role":"user"},` +
+		`{"content":"","role":"assistant","tool_calls":[{"function":{"arguments":"{}","name":"look"},"id":"c1","type":"function"}]},` +
+		`{"content":"a cat","role":"tool","tool_call_id":"c1"}]`
+	if string(body) != exp {
+		t.Fatalf("wanted\n%s\ngot\n%s", exp, body)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestWithHTTPClient(t *testing.T) {
+	var got *http.Request
+	hc := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		got = r
+		return &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{"Content-Type": {"text/event-stream"}},
+			Body:       io.NopCloser(strings.NewReader("data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\n")),
+		}, nil
+	})}
+
+	c, err := New(&llm.BackendConfig{URL: "http://fake/v1"}, WithHTTPClient(hc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := c.Call(context.Background(), &llm.Prompt{Messages: llm.Alternating("hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := llm.Collect(stream); err != nil || msg != "hi" {
+		t.Fatalf("wanted hi, got %q, %v", msg, err)
+	}
+	if got == nil || got.URL.String() != "http://fake/v1/chat/completions" {
+		t.Fatalf("wanted request through injected client, got %v", got)
+	}
+	if http.DefaultClient.Transport != nil {
+		t.Fatalf("http.DefaultClient was modified")
+	}
+}
+
+func TestListModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/v1/models" {
+			http.NotFound(w, r)
+			return
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer test" {
+			t.Errorf("wanted auth header, got %q", auth)
+		}
+		w.Write([]byte(`{"object":"list","data":[{"id":"qwen","max_model_len":32768},{"id":"gpt-4o","object":"model"}]}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("OPENAI_API_KEY", "test")
+	c, err := New(&llm.BackendConfig{URL: server.URL + "/v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	models, err := c.ListModels(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 2 || *models[0] != (llm.Model{Name: "gpt-4o"}) || *models[1] != (llm.Model{Name: "qwen", ContextLength: 32768}) {
+		t.Fatalf("wrong models: %v %v", models[0], models[1])
+	}
+}
+
+func TestStrictSchema(t *testing.T) {
+	for _, test := range []struct {
+		schema string
+		strict bool
+	}{
+		{`{"type":"object","properties":{"a":{"type":"string"}},"required":["a"],"additionalProperties":false}`, true},
+		{`{"type":"array","items":{"type":"object","properties":{"a":{"type":"string"}},"required":["a"],"additionalProperties":false}}`, true},
+		{`{"type":"object","properties":{"a":{"type":"string"}},"required":["a"]}`, false},
+		{`{"type":"object","properties":{"a":{"type":"string"},"b":{"type":"string"}},"required":["a"],"additionalProperties":false}`, false},
+		{`{"type":"object","properties":{"a":{"type":"object","properties":{}}},"required":["a"],"additionalProperties":false}`, false},
+	} {
+		if got := strictSchema(json.RawMessage(test.schema)); got != test.strict {
+			t.Errorf("%s: wanted strict %v, got %v", test.schema, test.strict, got)
+		}
 	}
 }
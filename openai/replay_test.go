@@ -0,0 +1,72 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/evmar/ai/llm"
+	"github.com/evmar/ai/net"
+)
+
+var weatherTool = &llm.Tool{
+	Name:        "weather",
+	Description: "Get the weather for a city",
+	Parameters:  json.RawMessage(`{"type":"object","properties":{"city":{"type":"string"}},"required":["city"]}`),
+}
+
+// replay returns a client that answers from the named testdata cassette.
+func replay(t *testing.T, name string) *Client {
+	cassette, err := net.LoadCassette("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OPENAI_API_KEY", "test")
+	c, err := New(&llm.BackendConfig{MaxAttempts: 1}, WithHTTPClient(&http.Client{Transport: net.NewReplayer(cassette)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestReplayToolCall(t *testing.T) {
+	c := replay(t, "tool_call.json")
+	stream, err := c.Call(context.Background(), &llm.Prompt{
+		System:   "Be brief.",
+		Messages: llm.Alternating("What's the weather in Paris?"),
+		Tools:    []*llm.Tool{weatherTool},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := llm.ReadResponse(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "Let me check." {
+		t.Errorf("wanted text, got %q", resp.Text)
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].Name != "weather" || string(resp.ToolCalls[0].Arguments) != `{"city":"Paris"}` {
+		t.Fatalf("wanted weather call, got %+v", resp.ToolCalls)
+	}
+	if resp.ToolCalls[0].ID != "call_abc123" || resp.FinishReason != "tool_calls" {
+		t.Errorf("wrong call id/finish: %q %q", resp.ToolCalls[0].ID, resp.FinishReason)
+	}
+	if resp.Usage == nil || resp.Usage.InputTokens != 62 || resp.Usage.OutputTokens != 19 {
+		t.Errorf("wanted usage 62/19, got %+v", resp.Usage)
+	}
+}
+
+func TestReplayError(t *testing.T) {
+	c := replay(t, "error.json")
+	_, err := c.Call(context.Background(), &llm.Prompt{Messages: llm.Alternating("hi")})
+	var apiErr *llm.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("wanted APIError, got %v", err)
+	}
+	if apiErr.Status != 401 || apiErr.Code != "invalid_api_key" || apiErr.Retryable() {
+		t.Fatalf("wrong error: %+v", apiErr)
+	}
+}
@@ -0,0 +1,325 @@
+package openai
+
+// Support for the Responses API, selected with api = "responses".
+// See https://platform.openai.com/docs/api-reference/responses.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/evmar/ai/llm"
+	"github.com/evmar/ai/net"
+	"github.com/evmar/ai/rawjson"
+)
+
+// inputContent converts message parts to Responses input content.
+func inputContent(parts []llm.Part) ([]interface{}, error) {
+	content := []interface{}{}
+	for _, part := range parts {
+		switch part := part.(type) {
+		case *llm.Text:
+			content = append(content, map[string]interface{}{
+				"type": "input_text",
+				"text": part.Text,
+			})
+		case *llm.Image:
+			content = append(content, map[string]interface{}{
+				"type":      "input_image",
+				"image_url": dataURL(part.MimeType, part.Data),
+				"detail":    "high",
+			})
+		case *llm.File:
+			content = append(content, map[string]interface{}{
+				"type":      "input_file",
+				"filename":  part.Name,
+				"file_data": dataURL(part.MimeType, part.Data),
+			})
+		default:
+			return nil, fmt.Errorf("openai: %T not supported by the responses api", part)
+		}
+	}
+	return content, nil
+}
+
+// inputItems converts the prompt's messages to Responses input items.
+// Tool calls and their results are items of their own.
+func inputItems(prompt *llm.Prompt) ([]interface{}, error) {
+	items := []interface{}{}
+	for _, msg := range prompt.Messages {
+		switch msg.Role {
+		case llm.RoleUser:
+			content, err := inputContent(msg.Parts)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, map[string]interface{}{
+				"role":    "user",
+				"content": content,
+			})
+
+		case llm.RoleAssistant:
+			if text := msg.Text(); text != "" {
+				items = append(items, map[string]interface{}{
+					"role": "assistant",
+					"content": []interface{}{
+						map[string]interface{}{"type": "output_text", "text": text},
+					},
+				})
+			}
+			for _, call := range msg.ToolCalls() {
+				items = append(items, map[string]interface{}{
+					"type":      "function_call",
+					"call_id":   call.ID,
+					"name":      call.Name,
+					"arguments": string(call.Arguments),
+				})
+			}
+
+		case llm.RoleTool:
+			for _, part := range msg.Parts {
+				r, ok := part.(*llm.ToolResult)
+				if !ok {
+					return nil, fmt.Errorf("openai: unexpected %T in tool message", part)
+				}
+				items = append(items, map[string]interface{}{
+					"type":    "function_call_output",
+					"call_id": r.ID,
+					"output":  r.Content,
+				})
+			}
+
+		default:
+			return nil, fmt.Errorf("openai: unknown role %q", msg.Role)
+		}
+	}
+	return items, nil
+}
+
+// responsesRequest builds the /responses request body for prompt.
+func (oai *Client) responsesRequest(prompt *llm.Prompt) (map[string]interface{}, error) {
+	input, err := inputItems(prompt)
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]interface{}{
+		"model":  oai.model,
+		"input":  input,
+		"stream": true,
+	}
+	if prompt.System != "" {
+		params["instructions"] = prompt.System
+	}
+
+	opts := oai.options.Merge(prompt.Options)
+	if opts.Temperature != nil {
+		params["temperature"] = *opts.Temperature
+	}
+	if opts.MaxTokens != nil {
+		params["max_output_tokens"] = *opts.MaxTokens
+	}
+	if opts.TopP != nil {
+		params["top_p"] = *opts.TopP
+	}
+	// Stop and Seed aren't supported.
+	if opts.ReasoningEffort != "" {
+		params["reasoning"] = map[string]interface{}{"effort": opts.ReasoningEffort}
+	}
+
+	if len(prompt.Tools) > 0 {
+		tools := []interface{}{}
+		for _, tool := range prompt.Tools {
+			tools = append(tools, map[string]interface{}{
+				"type":        "function",
+				"name":        tool.Name,
+				"description": tool.Description,
+				"parameters":  tool.Parameters,
+			})
+		}
+		params["tools"] = tools
+	}
+
+	if prompt.Schema != nil {
+		params["text"] = map[string]interface{}{
+			"format": map[string]interface{}{
+				"type":   "json_schema",
+				"name":   "response",
+				"schema": prompt.Schema,
+				"strict": strictSchema(prompt.Schema),
+			},
+		}
+	} else if prompt.JSON {
+		params["text"] = map[string]interface{}{
+			"format": map[string]interface{}{"type": "json_object"},
+		}
+	}
+	return params, nil
+}
+
+func (oai *Client) callResponses(ctx context.Context, prompt *llm.Prompt) (llm.Stream, error) {
+	params, err := oai.responsesRequest(prompt)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := oai.post(ctx, "/responses", params)
+	if err != nil {
+		return nil, err
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		// A server that ignored "stream", or a proxy's error page.
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return wholeResponse(ct, body)
+	}
+	return &ResponsesStream{r: net.NewSSEReader(resp.Body), body: resp.Body}, nil
+}
+
+// wholeResponse converts a non-streamed Responses body into the events
+// streaming it would have produced.
+func wholeResponse(contentType string, body []byte) (llm.Stream, error) {
+	var r responseBody
+	if err := json.Unmarshal(body, &r); err != nil || r.Object != "response" {
+		if j, err := rawjson.Parse(body); err == nil {
+			if e := getError(j); e != nil {
+				return nil, e
+			}
+		}
+		return nil, fmt.Errorf("openai: wanted an event stream, got %q: %s", contentType, llm.Snippet(body))
+	}
+	s := &ResponsesStream{}
+	for _, item := range r.Output {
+		if item.Type == "message" {
+			for _, c := range item.Content {
+				if c.Type == "output_text" {
+					s.handle(&responseEvent{Type: "response.output_text.delta", Delta: c.Text})
+				}
+			}
+			continue
+		}
+		s.handle(&responseEvent{Type: "response.output_item.done", Item: item})
+	}
+	if err := s.handle(&responseEvent{Type: "response." + r.Status, Response: &r}); err != nil {
+		return nil, err
+	}
+	return llm.StaticStream(s.pending...), nil
+}
+
+// responseItem is an output item: a message or a function call.
+type responseItem struct {
+	Type      string `json:"type"`
+	CallID    string `json:"call_id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	// Only in whole responses; streams send the text as deltas.
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+}
+
+// responseBody is a response object, as sent whole or in the final event.
+type responseBody struct {
+	Object            string `json:"object"`
+	Status            string `json:"status"`
+	IncompleteDetails *struct {
+		Reason string `json:"reason"`
+	} `json:"incomplete_details"`
+	Error *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	Usage *struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Output []*responseItem `json:"output"`
+}
+
+// responseEvent is one streamed Responses event.  Only the fields of the
+// events we use are declared.
+type responseEvent struct {
+	Type string `json:"type"`
+	// response.output_text.delta
+	Delta string `json:"delta"`
+	// response.output_item.done
+	Item *responseItem `json:"item"`
+	// response.completed, response.incomplete, response.failed
+	Response *responseBody `json:"response"`
+	// error
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ResponsesStream reads a streamed Responses API response.
+type ResponsesStream struct {
+	r       *net.SSEReader
+	body    io.Closer
+	pending []llm.Event
+}
+
+// handle converts one event into pending stream events.
+func (s *ResponsesStream) handle(ev *responseEvent) error {
+	switch ev.Type {
+	case "response.output_text.delta":
+		s.pending = append(s.pending, &llm.TextDelta{Text: ev.Delta})
+	case "response.output_item.done":
+		// Function call arguments also stream as deltas, but the finished
+		// item has them whole.
+		if item := ev.Item; item != nil && item.Type == "function_call" {
+			args := json.RawMessage(item.Arguments)
+			if len(args) == 0 {
+				args = json.RawMessage("{}")
+			}
+			s.pending = append(s.pending, &llm.ToolCall{ID: item.CallID, Name: item.Name, Arguments: args})
+		}
+	case "response.completed", "response.incomplete":
+		r := ev.Response
+		if r == nil {
+			break
+		}
+		reason := r.Status
+		if r.IncompleteDetails != nil {
+			reason = r.IncompleteDetails.Reason // e.g. "max_output_tokens"
+		}
+		s.pending = append(s.pending, &llm.Finish{Reason: reason})
+		if r.Usage != nil {
+			s.pending = append(s.pending, &llm.Usage{InputTokens: r.Usage.InputTokens, OutputTokens: r.Usage.OutputTokens})
+		}
+	case "response.failed":
+		if r := ev.Response; r != nil && r.Error != nil {
+			return &llm.APIError{Provider: "openai", Code: r.Error.Code, Message: r.Error.Message}
+		}
+		return &llm.APIError{Provider: "openai", Message: "response failed"}
+	case "error":
+		return &llm.APIError{Provider: "openai", Code: ev.Code, Message: ev.Message}
+	}
+	return nil
+}
+
+func (s *ResponsesStream) Next() (llm.Event, error) {
+	for len(s.pending) == 0 {
+		data, err := s.r.Read()
+		if err != nil {
+			return nil, err
+		}
+		var ev responseEvent
+		if err := json.Unmarshal(data, &ev); err != nil {
+			return nil, &ParseError{Data: data, Err: err}
+		}
+		if err := s.handle(&ev); err != nil {
+			return nil, err
+		}
+	}
+	ev := s.pending[0]
+	s.pending = s.pending[1:]
+	return ev, nil
+}
+
+func (s *ResponsesStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,152 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/evmar/ai/llm"
+)
+
+func TestResponsesRequest(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "test")
+	c, err := New(&llm.BackendConfig{API: "responses", Model: "o4-mini", Options: llm.Options{ReasoningEffort: "high"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxTokens := 100
+	params, err := c.responsesRequest(&llm.Prompt{
+		System: "sys",
+		Messages: []*llm.Message{
+			{Role: llm.RoleUser, Parts: []llm.Part{
+				&llm.Image{MimeType: "image/png", Data: []byte("png")},
+				&llm.Text{Text: "what's this?"},
+			}},
+			{Role: llm.RoleAssistant, Parts: []llm.Part{
+				&llm.Text{Text: "Looking."},
+				&llm.ToolCall{ID: "c1", Name: "look", Arguments: json.RawMessage(`{}`)},
+			}},
+			{Role: llm.RoleTool, Parts: []llm.Part{
+				&llm.ToolResult{ID: "c1", Name: "look", Content: "a cat"},
+			}},
+		},
+		JSON:    true,
+		Options: llm.Options{MaxTokens: &maxTokens},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"input":[` +
+		`{"content":[{"detail":"high","image_url":"data:image/png;base64,cG5n","type":"input_image"},{"text":"what's this?","type":"input_text"}],"role":"user"},` +
+		`{"content":[{"text":"Looking.","type":"output_text"}],"role":"assistant"},` +
+		`{"arguments":"{}","call_id":"c1","name":"look","type":"function_call"},` +
+		`{"call_id":"c1","output":"a cat","type":"function_call_output"}],` +
+		`"instructions":"sys","max_output_tokens":100,"model":"o4-mini","reasoning":{"effort":"high"},"stream":true,` +
+		`"text":{"format":{"type":"json_object"}}}`
+	if string(body) != exp {
+		t.Fatalf("wanted\n%s\ngot\n%s", exp, body)
+	}
+
+	if _, err := c.responsesRequest(&llm.Prompt{Messages: []*llm.Message{
+		{Role: llm.RoleUser, Parts: []llm.Part{&llm.Audio{MimeType: "audio/wav"}}},
+	}}); err == nil {
+		t.Fatalf("wanted error for audio part")
+	}
+}
+
+func TestInvalidAPI(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "test")
+	if _, err := New(&llm.BackendConfig{API: "completions"}); err == nil {
+		t.Fatalf("wanted error for invalid api")
+	}
+}
+
+func TestReplayResponses(t *testing.T) {
+	c := replay(t, "responses.json")
+	c.api = "responses"
+	stream, err := c.Call(context.Background(), &llm.Prompt{
+		System:   "Be brief.",
+		Messages: llm.Alternating("What's the weather in Paris?"),
+		Tools:    []*llm.Tool{weatherTool},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := llm.ReadResponse(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "Let me check." {
+		t.Errorf("wanted text, got %q", resp.Text)
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].ID != "call_xyz789" || string(resp.ToolCalls[0].Arguments) != `{"city":"Paris"}` {
+		t.Fatalf("wanted weather call, got %+v", resp.ToolCalls)
+	}
+	if resp.FinishReason != "completed" {
+		t.Errorf("wanted finish completed, got %q", resp.FinishReason)
+	}
+	if resp.Usage == nil || resp.Usage.InputTokens != 58 || resp.Usage.OutputTokens != 21 {
+		t.Errorf("wanted usage 58/21, got %+v", resp.Usage)
+	}
+}
+
+func TestResponsesNotStreamed(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "")
+	for _, test := range []struct {
+		contentType, body string
+		want, err         string
+	}{
+		{"application/json", `{"object":"response","status":"incomplete","incomplete_details":{"reason":"max_output_tokens"},` +
+			`"output":[{"type":"message","content":[{"type":"output_text","text":"Let me check."}]},` +
+			`{"type":"function_call","call_id":"c1","name":"weather","arguments":"{\"city\":\"Paris\"}"}],` +
+			`"usage":{"input_tokens":5,"output_tokens":7}}`,
+			`Let me check.|weather {"city":"Paris"}|max_output_tokens|5/7`, ""},
+		{"application/json", `{"error":"model not loaded"}`, "", "model not loaded"},
+		{"text/html", "<html>bad gateway</html>", "", `wanted an event stream, got "text/html": <html>bad gateway</html>`},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", test.contentType)
+			w.Write([]byte(test.body))
+		}))
+		defer server.Close()
+
+		c, err := New(&llm.BackendConfig{URL: server.URL, API: "responses", Model: "m"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream, err := c.Call(context.Background(), &llm.Prompt{Messages: llm.Alternating("hi")})
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("wanted error %q, got %v", test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := llm.ReadResponse(stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		got = append(got, resp.Text)
+		for _, call := range resp.ToolCalls {
+			got = append(got, call.Name+" "+string(call.Arguments))
+		}
+		got = append(got, resp.FinishReason)
+		if resp.Usage != nil {
+			got = append(got, fmt.Sprintf("%d/%d", resp.Usage.InputTokens, resp.Usage.OutputTokens))
+		}
+		if strings.Join(got, "|") != test.want {
+			t.Fatalf("wanted %s, got %s", test.want, strings.Join(got, "|"))
+		}
+	}
+}
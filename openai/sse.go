@@ -0,0 +1,146 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/evmar/ai/llm"
+	"github.com/evmar/ai/net"
+)
+
+// ParseError is returned when a streamed chunk isn't the expected JSON.
+type ParseError struct {
+	Data []byte
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("openai: parsing stream chunk %q: %s", e.Data, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// delta is a streamed piece of a message, or in a whole completion, all of it.
+type delta struct {
+	Content   string `json:"content"`
+	ToolCalls []struct {
+		Index    int    `json:"index"`
+		ID       string `json:"id"`
+		Function struct {
+			Name      string `json:"name"`
+			Arguments string `json:"arguments"`
+		} `json:"function"`
+	} `json:"tool_calls"`
+}
+
+// chunk is one chat.completion.chunk object from a streamed response.
+type chunk struct {
+	Choices []struct {
+		Delta        delta  `json:"delta"`
+		Message      *delta `json:"message"` // only in a whole chat.completion
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Error *struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Code    interface{} `json:"code"`
+	} `json:"error"`
+	// Usage is only set on the final chunk, when requested with
+	// stream_options.include_usage.
+	Usage *usage `json:"usage"`
+}
+
+// usage is the token counts of a response.
+type usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+func (u *usage) event() *llm.Usage {
+	return &llm.Usage{InputTokens: u.PromptTokens, OutputTokens: u.CompletionTokens}
+}
+
+func parseChunk(data []byte) (*chunk, error) {
+	var c chunk
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, &ParseError{Data: data, Err: err}
+	}
+	if c.Error != nil {
+		code, _ := c.Error.Code.(string)
+		return nil, &llm.APIError{Provider: "openai", Type: c.Error.Type, Code: code, Message: c.Error.Message}
+	}
+	return &c, nil
+}
+
+type Stream struct {
+	r       *net.SSEReader
+	body    io.Closer
+	pending []llm.Event
+	// Tool calls arrive in fragments, keyed by index, and are emitted
+	// once the choice finishes.
+	toolCalls []*llm.ToolCall
+}
+
+// handle converts one streamed chunk into pending events.
+func (s *Stream) handle(c *chunk) {
+	for _, choice := range c.Choices {
+		if choice.Delta.Content != "" {
+			s.pending = append(s.pending, &llm.TextDelta{Text: choice.Delta.Content})
+		}
+		for _, tc := range choice.Delta.ToolCalls {
+			for len(s.toolCalls) <= tc.Index {
+				s.toolCalls = append(s.toolCalls, &llm.ToolCall{})
+			}
+			call := s.toolCalls[tc.Index]
+			if tc.ID != "" {
+				call.ID = tc.ID
+			}
+			call.Name += tc.Function.Name
+			call.Arguments = append(call.Arguments, tc.Function.Arguments...)
+		}
+		if choice.FinishReason != "" {
+			s.flushToolCalls()
+			s.pending = append(s.pending, &llm.Finish{Reason: choice.FinishReason})
+		}
+	}
+	if c.Usage != nil {
+		s.pending = append(s.pending, c.Usage.event())
+	}
+}
+
+func (s *Stream) flushToolCalls() {
+	for _, call := range s.toolCalls {
+		if len(call.Arguments) == 0 {
+			call.Arguments = json.RawMessage("{}")
+		}
+		s.pending = append(s.pending, call)
+	}
+	s.toolCalls = nil
+}
+
+func (s *Stream) Next() (llm.Event, error) {
+	for len(s.pending) == 0 {
+		data, err := s.r.Read()
+		if err == io.EOF && len(s.toolCalls) > 0 {
+			s.flushToolCalls()
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		c, err := parseChunk(data)
+		if err != nil {
+			return nil, err
+		}
+		s.handle(c)
+	}
+	ev := s.pending[0]
+	s.pending = s.pending[1:]
+	return ev, nil
+}
+
+func (s *Stream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,100 @@
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/evmar/ai/llm"
+	"github.com/evmar/ai/net"
+)
+
+func TestStream(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    []llm.Event
+		wantErr interface{}
+	}{
+		{
+			name: "text",
+			raw: `data: {"choices":[{"index":0,"delta":{"role":"assistant","content":""},"finish_reason":null}]}
+
+data: {"choices":[{"index":0,"delta":{"content":"Hello"},"finish_reason":null}]}
+
+data: {"choices":[{"index":0,"delta":{"content":"!"},"finish_reason":null}]}
+
+data: {"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}
+
+data: [DONE]
+
+`,
+			want: []llm.Event{
+				&llm.TextDelta{Text: "Hello"},
+				&llm.TextDelta{Text: "!"},
+				&llm.Finish{Reason: "stop"},
+			},
+		},
+		{
+			name: "tool call",
+			raw: `data: {"choices":[{"index":0,"delta":{"role":"assistant","content":null,"tool_calls":[{"index":0,"id":"call_abc","type":"function","function":{"name":"decl","arguments":""}}]},"finish_reason":null}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"name\":"}}]},"finish_reason":null}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"main\"}"}}]},"finish_reason":null}]}
+
+data: {"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}
+
+data: [DONE]
+
+`,
+			want: []llm.Event{
+				&llm.ToolCall{ID: "call_abc", Name: "decl", Arguments: json.RawMessage(`{"name":"main"}`)},
+				&llm.Finish{Reason: "tool_calls"},
+			},
+		},
+		{
+			name: "mid-stream error",
+			raw: `data: {"choices":[{"index":0,"delta":{"content":"Hel"},"finish_reason":null}]}
+
+data: {"error":{"message":"The server had an error while processing your request.","type":"server_error"}}
+
+`,
+			want:    []llm.Event{&llm.TextDelta{Text: "Hel"}},
+			wantErr: new(*llm.APIError),
+		},
+		{
+			name:    "bad json",
+			raw:     "data: {\"choices\":\n\n",
+			wantErr: new(*ParseError),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Stream{r: net.NewSSEReader(strings.NewReader(test.raw))}
+			var got []llm.Event
+			var err error
+			for {
+				var ev llm.Event
+				ev, err = s.Next()
+				if err != nil {
+					break
+				}
+				got = append(got, ev)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("wanted events %v, got %v", test.want, got)
+			}
+			if test.wantErr == nil {
+				if err != io.EOF {
+					t.Errorf("wanted EOF, got %v", err)
+				}
+			} else if !errors.As(err, test.wantErr) {
+				t.Errorf("wanted %T, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
@@ -24,14 +24,32 @@ func (r *RJSON) Map() map[string]interface{} {
 	return r.data.(map[string]interface{})
 }
 
+// Get returns the value at key, or nil if it's missing or r isn't an object.
 func (r *RJSON) Get(key string) *RJSON {
-	val := r.Map()[key]
+	m, ok := r.data.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	val := m[key]
 	if val == nil {
 		return nil
 	}
 	return New(val)
 }
 
+// OptString returns the string at key, or "" if it's missing or not a string.
+func (r *RJSON) OptString(key string) string {
+	m, _ := r.data.(map[string]interface{})
+	s, _ := m[key].(string)
+	return s
+}
+
+// IsString reports whether r holds a string.
+func (r *RJSON) IsString() bool {
+	_, ok := r.data.(string)
+	return ok
+}
+
 func (r *RJSON) Array() []interface{} {
 	return r.data.([]interface{})
 }
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/evmar/ai/llm"
+	"github.com/evmar/ai/llm/ledger"
+)
+
+// metered wraps a backend to record each call in the usage ledger, enforce
+// the budget, and with -usage report each call's usage and latency on stderr.
+type metered struct {
+	llm.LLM
+	backend, model string
+	// price is the model's price, if configured.
+	price  *llm.Price
+	budget *llm.Budget
+}
+
+// effectiveModel returns the model backend calls, which may be a backend
+// default not named in cfg.
+func effectiveModel(backend llm.LLM, cfg *llm.BackendConfig) string {
+	if b, ok := backend.(interface{ Model() string }); ok {
+		return b.Model()
+	}
+	return cfg.Model
+}
+
+func meter(backend llm.LLM, config *llm.Config, name string, cfg *llm.BackendConfig) llm.LLM {
+	model := effectiveModel(backend, cfg)
+	if b := config.Budget; b != nil && (b.Daily > 0 || b.Monthly > 0) && config.Price[model] == nil {
+		fmt.Fprintf(os.Stderr, "warning: no [price.%q] in config, so the budget can't count its calls\n", model)
+	}
+	return &metered{
+		LLM:     backend,
+		backend: name,
+		model:   model,
+		price:   config.Price[model],
+		budget:  config.Budget,
+	}
+}
+
+func (m *metered) Model() string {
+	return m.model
+}
+
+func (m *metered) Call(ctx context.Context, prompt *llm.Prompt) (llm.Stream, error) {
+	if err := checkBudget(m.budget, time.Now()); err != nil {
+		return nil, err
+	}
+	s := &meteredStream{m: m, start: time.Now()}
+	stream, err := m.LLM.Call(ctx, prompt)
+	if err != nil {
+		s.finish(err)
+		return nil, err
+	}
+	s.Stream = stream
+	return s, nil
+}
+
+type meteredStream struct {
+	llm.Stream
+	m     *metered
+	start time.Time
+	// first is the time until the first output arrived.
+	first    time.Duration
+	usage    *llm.Usage
+	finished bool
+}
+
+func (s *meteredStream) Next() (llm.Event, error) {
+	ev, err := s.Stream.Next()
+	switch ev := ev.(type) {
+	case *llm.TextDelta, *llm.ToolCall:
+		if s.first == 0 {
+			s.first = time.Since(s.start)
+		}
+	case *llm.Usage:
+		s.usage = ev
+	}
+	if err == io.EOF {
+		s.finish(nil)
+	} else if err != nil {
+		s.finish(err)
+	}
+	return ev, err
+}
+
+func (s *meteredStream) Close() error {
+	// A stream abandoned before its end still counts.
+	s.finish(fmt.Errorf("closed before end"))
+	return s.Stream.Close()
+}
+
+// finish records the call, once.
+func (s *meteredStream) finish(err error) {
+	if s.finished {
+		return
+	}
+	s.finished = true
+	total := time.Since(s.start)
+
+	r := &ledger.Record{
+		Time:      s.start,
+		Backend:   s.m.backend,
+		Model:     s.m.model,
+		LatencyMs: total.Milliseconds(),
+		Status:    "ok",
+	}
+	if err != nil {
+		r.Status = err.Error()
+	}
+	if s.usage != nil {
+		r.InputTokens = s.usage.InputTokens
+		r.OutputTokens = s.usage.OutputTokens
+		if s.m.price != nil {
+			r.Cost = s.m.price.Cost(s.usage)
+		}
+	}
+	// Replayed calls cost nothing.
+	if *flagReplay == "" {
+		if err := ledger.Append(r); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: recording usage: %s\n", err)
+		}
+	}
+
+	if *flagUsage && err == nil {
+		fmt.Fprintln(os.Stderr, s.report(total))
+	}
+}
+
+func (s *meteredStream) report(total time.Duration) string {
+	msg := "usage: tokens unknown"
+	if s.usage != nil {
+		msg = fmt.Sprintf("usage: %d in + %d out tokens", s.usage.InputTokens, s.usage.OutputTokens)
+	}
+	msg += fmt.Sprintf(", %.2fs", total.Seconds())
+	if s.first != 0 {
+		msg += fmt.Sprintf(" (first output %.2fs)", s.first.Seconds())
+	}
+	if s.usage != nil && s.m.price != nil {
+		msg += fmt.Sprintf(", ~$%.6f", s.m.price.Cost(s.usage))
+	}
+	return msg
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func startOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// checkBudget refuses further calls once the budget is spent.
+func checkBudget(b *llm.Budget, now time.Time) error {
+	if b == nil || (b.Daily == 0 && b.Monthly == 0) {
+		return nil
+	}
+	records, err := ledger.Read(startOfMonth(now))
+	if err != nil {
+		return err
+	}
+	if spent := ledger.Spent(records, startOfDay(now)); b.Daily > 0 && spent >= b.Daily {
+		return fmt.Errorf("daily budget of $%.2f spent ($%.2f); see `ai usage`", b.Daily, spent)
+	}
+	if spent := ledger.Spent(records, startOfMonth(now)); b.Monthly > 0 && spent >= b.Monthly {
+		return fmt.Errorf("monthly budget of $%.2f spent ($%.2f); see `ai usage`", b.Monthly, spent)
+	}
+	return nil
+}
+
+func runUsage(config *llm.Config, args []string) error {
+	flags := flag.NewFlagSet("usage", flag.ExitOnError)
+	by := flags.String("by", "day", "group by {day,backend,model}")
+	days := flags.Int("days", 7, "days of history to include")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return fmt.Errorf("usage takes no arguments")
+	}
+
+	var key func(*ledger.Record) string
+	switch *by {
+	case "day":
+		key = func(r *ledger.Record) string { return r.Time.Local().Format("2006-01-02") }
+	case "backend":
+		key = func(r *ledger.Record) string { return r.Backend }
+	case "model":
+		key = func(r *ledger.Record) string { return r.Backend + "/" + r.Model }
+	default:
+		return fmt.Errorf("invalid -by %q, must be one of {day,backend,model}", *by)
+	}
+
+	now := time.Now()
+	since := startOfDay(now).AddDate(0, 0, 1-*days)
+	records, err := ledger.Read(since)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "%s\tcalls\terrors\tin tokens\tout tokens\tavg latency\tcost\t\n", *by)
+	var all ledger.Total
+	for _, t := range ledger.Summarize(records, key) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%.2fs\t$%.4f\t\n", t.Key, t.Calls, t.Errors, t.InputTokens, t.OutputTokens,
+			(t.Latency / time.Duration(t.Calls)).Seconds(), t.Cost)
+		all.Calls += t.Calls
+		all.Errors += t.Errors
+		all.InputTokens += t.InputTokens
+		all.OutputTokens += t.OutputTokens
+		all.Cost += t.Cost
+	}
+	fmt.Fprintf(w, "total\t%d\t%d\t%d\t%d\t\t$%.4f\t\n", all.Calls, all.Errors, all.InputTokens, all.OutputTokens, all.Cost)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if b := config.Budget; b != nil {
+		month, err := ledger.Read(startOfMonth(now))
+		if err != nil {
+			return err
+		}
+		fmt.Println()
+		if b.Daily > 0 {
+			fmt.Printf("today: $%.4f of $%.2f daily budget\n", ledger.Spent(month, startOfDay(now)), b.Daily)
+		}
+		if b.Monthly > 0 {
+			fmt.Printf("this month: $%.4f of $%.2f monthly budget\n", ledger.Spent(month, startOfMonth(now)), b.Monthly)
+		}
+	}
+	return nil
+}
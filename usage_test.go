@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evmar/ai/llm"
+	"github.com/evmar/ai/llm/ledger"
+)
+
+// fakeOpenAI serves chat completions with a fixed reply and usage.
+func fakeOpenAI(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"hi\"},\"finish_reason\":\"stop\"}]}\n\n" +
+			"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":1000000,\"completion_tokens\":0}}\n\ndata: [DONE]\n\n"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// defaultModelConfig configures a backend that uses its default model,
+// gpt-4o-mini, with a price for it.
+func defaultModelConfig(t *testing.T) *llm.Config {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	t.Setenv("OPENAI_API_KEY", "")
+	return &llm.Config{
+		DefaultBackend: "local",
+		Backend: map[string]*llm.BackendConfig{
+			"local": {Mode: "openai", URL: fakeOpenAI(t).URL},
+		},
+		Price: map[string]*llm.Price{"gpt-4o-mini": {Input: 0.15, Output: 0.60}},
+	}
+}
+
+func TestMeterDefaultModel(t *testing.T) {
+	config := defaultModelConfig(t)
+	if err := runText(context.Background(), config, []string{"hello"}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := ledger.Read(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Model != "gpt-4o-mini" || records[0].Cost != 0.15 {
+		t.Fatalf("wanted a gpt-4o-mini call costing $0.15, got %+v", records)
+	}
+}
+
+func TestBudgetDefaultModel(t *testing.T) {
+	config := defaultModelConfig(t)
+	config.Budget = &llm.Budget{Daily: 0.10}
+	ctx := context.Background()
+	if err := runText(ctx, config, []string{"hello"}); err != nil {
+		t.Fatal(err)
+	}
+	// The first call cost $0.15, over the daily budget.
+	err := runText(ctx, config, []string{"hello"})
+	if err == nil || !strings.Contains(err.Error(), "daily budget of $0.10 spent ($0.15)") {
+		t.Fatalf("wanted daily budget error, got %v", err)
+	}
+}